@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/peer"
+)
+
+// Event types recorded in the audit log.
+const (
+	AuthSuccess    = "auth_success"
+	AuthFailure    = "auth_failure"
+	TokenRefreshed = "token_refreshed"
+	StreamStart    = "stream_start"
+	StreamEnd      = "stream_end"
+	OptionsChanged = "options_changed"
+	AdminAction    = "admin_action"
+)
+
+// Event is one audit record. Credentials never go in an event as they are, only their Fingerprint.
+type Event struct {
+	Time       time.Time              `json:"time"`
+	Type       string                 `json:"type"`
+	ClientId   string                 `json:"client_id,omitempty"`
+	Peer       string                 `json:"peer,omitempty"`
+	Credential string                 `json:"credential,omitempty"`
+	Reason     string                 `json:"reason,omitempty"`
+	Fields     map[string]interface{} `json:"fields,omitempty"`
+}
+
+type Sink interface {
+	Write(event Event) error
+}
+
+type SinkFunc func(event Event) error
+
+func (f SinkFunc) Write(event Event) error {
+	return f(event)
+}
+
+// JSONLines writes each event as a line of JSON.
+type JSONLines struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{writer: w}
+}
+
+// Open appends events to the file in path, "stdout" writes them to the standard output.
+func Open(path string) (*JSONLines, error) {
+	if path == "stdout" {
+		return NewJSONLines(os.Stdout), nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %v", err)
+	}
+
+	return NewJSONLines(file), nil
+}
+
+func (j *JSONLines) Write(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	_, err = j.writer.Write(append(line, '\n'))
+	return err
+}
+
+var (
+	mutex sync.RWMutex
+	sink  Sink
+)
+
+// SetSink sets where events are recorded, nil discards them.
+func SetSink(s Sink) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	sink = s
+}
+
+// Record stamps the event and sends it to the sink. Audit failures are logged but never fail the request.
+func Record(event Event) {
+	mutex.RLock()
+	s := sink
+	mutex.RUnlock()
+
+	if s == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	err := s.Write(event)
+	if err != nil {
+		log.Errorf("error recording %s audit event: %v", event.Type, err)
+	}
+}
+
+// Fingerprint identifies a secret in the audit log without revealing it.
+func Fingerprint(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// Peer returns the address of the client owning the context.
+func Peer(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	return p.Addr.String()
+}
@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	var buffer bytes.Buffer
+	SetSink(NewJSONLines(&buffer))
+	defer SetSink(nil)
+
+	Record(Event{Type: AuthFailure, Credential: Fingerprint("secret token"), Reason: "token is not active"})
+	Record(Event{Type: StreamStart, ClientId: "client"})
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 events, got %v", len(lines))
+	}
+
+	var event Event
+	err := json.Unmarshal([]byte(lines[0]), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Type != AuthFailure || event.Time.IsZero() {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	if strings.Contains(buffer.String(), "secret token") {
+		t.Error("audit log leaks the credential")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	if Fingerprint("a") == Fingerprint("b") {
+		t.Error("different secrets should have different fingerprints")
+	}
+
+	if Fingerprint("") != "" {
+		t.Error("missing secrets should have no fingerprint")
+	}
+}
@@ -0,0 +1,220 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+// APIKey is a long-lived credential sent in the x-api-key header. Only the SHA-256 hash of the
+// key is kept.
+type APIKey struct {
+	Hash   string   `json:"key_hash"`
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+	Tier   string   `json:"tier"`
+}
+
+type APIKeySource interface {
+	LoadAPIKeys() ([]APIKey, error)
+}
+
+// APIKeyTracker is implemented by sources that keep track of when each key was last used. Uses
+// are batched and recorded once per reload.
+type APIKeyTracker interface {
+	APIKeysUsed(hashes []string) error
+}
+
+type APIKeySourceFunc func() ([]APIKey, error)
+
+func (f APIKeySourceFunc) LoadAPIKeys() ([]APIKey, error) {
+	return f()
+}
+
+// APIKeyFile loads API keys from a JSON array of keys. Entries may hold the plain key in a "key"
+// field instead of "key_hash", it is hashed when loaded.
+type APIKeyFile string
+
+func (f APIKeyFile) LoadAPIKeys() ([]APIKey, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return nil, fmt.Errorf("error reading API keys file: %v", err)
+	}
+
+	var entries []struct {
+		APIKey
+		Key string `json:"key"`
+	}
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling API keys file: %v", err)
+	}
+
+	keys := make([]APIKey, 0, len(entries))
+	for _, e := range entries {
+		if e.Key != "" {
+			e.Hash = hash(e.Key)
+		}
+
+		if e.Hash == "" || e.Owner == "" {
+			return nil, errors.New("API keys need a key or key_hash and an owner")
+		}
+
+		keys = append(keys, e.APIKey)
+	}
+
+	return keys, nil
+}
+
+type APIKeyAuthenticator struct {
+	source APIKeySource
+	mutex  sync.RWMutex
+	keys   map[string]APIKey
+
+	usedMutex sync.Mutex
+	used      map[string]bool
+}
+
+func NewAPIKeyAuthenticator(source APIKeySource) (*APIKeyAuthenticator, error) {
+	a := &APIKeyAuthenticator{source: source, used: make(map[string]bool)}
+
+	err := a.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *APIKeyAuthenticator) Reload() error {
+	loaded, err := a.source.LoadAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]APIKey, len(loaded))
+	for _, k := range loaded {
+		keys[k.Hash] = k
+	}
+
+	a.mutex.Lock()
+	a.keys = keys
+	a.mutex.Unlock()
+
+	log.Infof("loaded %v API key(s)", len(keys))
+
+	return nil
+}
+
+// Run records the keys used since the last reload and reloads the keys every interval until the
+// context is done, so new and removed keys are picked up without a restart. Removed keys keep
+// working for up to one interval.
+func (a *APIKeyAuthenticator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := a.Flush()
+			if err != nil {
+				log.Errorf("error recording API key use: %v", err)
+			}
+
+			err = a.Reload()
+			if err != nil {
+				log.Errorf("error reloading API keys, keeping the previous ones: %v", err)
+			}
+		case <-ctx.Done():
+			err := a.Flush()
+			if err != nil {
+				log.Errorf("error recording API key use: %v", err)
+			}
+
+			return
+		}
+	}
+}
+
+// Flush hands the keys used since the last flush to the source, when it tracks them. Keys that
+// couldn't be recorded are tried again at the next flush.
+func (a *APIKeyAuthenticator) Flush() error {
+	tracker, ok := a.source.(APIKeyTracker)
+	if !ok {
+		return nil
+	}
+
+	a.usedMutex.Lock()
+	used := a.used
+	a.used = make(map[string]bool)
+	a.usedMutex.Unlock()
+
+	if len(used) == 0 {
+		return nil
+	}
+
+	hashes := make([]string, 0, len(used))
+	for h := range used {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	err := tracker.APIKeysUsed(hashes)
+	if err != nil {
+		a.usedMutex.Lock()
+		for _, h := range hashes {
+			a.used[h] = true
+		}
+		a.usedMutex.Unlock()
+	}
+
+	return err
+}
+
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context) (Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md["x-api-key"]
+	if len(values) < 1 {
+		return Principal{}, ErrNoCredentials
+	}
+
+	a.mutex.RLock()
+	key, ok := a.keys[hash(values[0])]
+	a.mutex.RUnlock()
+
+	if !ok {
+		return Principal{}, errors.New("unknown API key")
+	}
+
+	if _, ok := a.source.(APIKeyTracker); ok {
+		a.usedMutex.Lock()
+		a.used[key.Hash] = true
+		a.usedMutex.Unlock()
+	}
+
+	return Principal{ID: key.Owner, Scopes: key.Scopes, Tier: key.Tier}, nil
+}
+
+// NewAPIKey generates a random API key, returning the key to hand to its owner and the hash to store.
+func NewAPIKey() (string, string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating API key: %v", err)
+	}
+
+	key := "nf_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	return key, hash(key), nil
+}
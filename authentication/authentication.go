@@ -2,113 +2,196 @@ package authentication
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
 	"strings"
+	"time"
 
+	"github.com/nearbyflights/nearbyflights/audit"
+	"github.com/nearbyflights/nearbyflights/tracing"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
-var introspection string
-
-type Introspection struct {
-	Active     bool   `json:"active"`
-	ClientId   string `json:"client_id"`
-	Expiration int    `json:"exp"`
-	Iat        int    `json:"iat"`
-	Issuer     string `json:"iss"`
-	Scope      string `json:"scope"`
-	Sub        string `json:"sub"`
-	TokenType  string `json:"token_type"`
+// ErrNoCredentials is returned by an Authenticator when the request carries none of the
+// credentials it understands, so the next authenticator in a Chain gets a chance.
+var ErrNoCredentials = errors.New("no credentials")
+
+// unavailableError is returned when the credentials couldn't be checked, such as while the
+// introspection endpoint is down, as opposed to credentials found to be invalid.
+type unavailableError struct {
+	err error
 }
 
-func NewAuthInterceptor(introspectionUrl string) func(interface{}, grpc.ServerStream, *grpc.StreamServerInfo, grpc.StreamHandler) error {
-	introspection = introspectionUrl
-	return validateToken
+func (e unavailableError) Error() string {
+	return e.err.Error()
 }
 
-func GetClientId(ctx context.Context) (string, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return "", errors.New("error while reading metadata")
-	}
+func (e unavailableError) Unwrap() error {
+	return e.err
+}
 
-	clientId := md[clientId.String()]
-	if len(clientId) < 1 {
-		return "", errors.New("invalid client id")
+// IsUnavailable tells whether the error says the credentials couldn't be checked, rather than
+// that they are invalid.
+func IsUnavailable(err error) bool {
+	var unavailable unavailableError
+	return errors.As(err, &unavailable)
+}
+
+// Principal is the authenticated identity behind a stream.
+type Principal struct {
+	ID     string
+	Scopes []string
+	Tier   string
+	// ExpiresAt is when the credentials stop being valid, zero for credentials that don't expire.
+	ExpiresAt time.Time
+}
+
+type Authenticator interface {
+	Authenticate(ctx context.Context) (Principal, error)
+}
+
+// Chain tries each authenticator in order and uses the first one that finds credentials.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context) (Principal, error) {
+	for _, a := range c {
+		principal, err := a.Authenticate(ctx)
+		if err == ErrNoCredentials {
+			continue
+		}
+
+		return principal, err
 	}
 
-	return clientId[0], nil
+	return Principal{}, ErrNoCredentials
 }
 
-func validateToken(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	ctx := stream.Context()
+var tracer = otel.Tracer("github.com/nearbyflights/nearbyflights/authentication")
 
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return status.Errorf(codes.InvalidArgument, "missing metadata")
+func NewAuthInterceptor(a Authenticator) func(interface{}, grpc.ServerStream, *grpc.StreamServerInfo, grpc.StreamHandler) error {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return validateToken(a, srv, stream, handler)
 	}
+}
 
-	ok, id := valid(md["authorization"])
-	if !ok {
-		return status.Errorf(codes.Unauthenticated, "invalid token")
+// NewUnaryAuthInterceptor authenticates unary calls like NewAuthInterceptor does with streams.
+func NewUnaryAuthInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return validateUnaryToken(ctx, a, req, handler)
 	}
+}
 
-	md.Set(clientId.String(), id)
+// GetClientId returns the ID of the principal authenticated for the stream owning the context.
+func GetClientId(ctx context.Context) (string, error) {
+	p, ok := FromContext(ctx)
+	if !ok || p.ID == "" {
+		return "", errors.New("invalid client id")
+	}
 
-	fmt.Printf("set client ID to %v \n", id)
+	return p.ID, nil
+}
+
+func validateToken(authenticator Authenticator, srv interface{}, stream grpc.ServerStream, handler grpc.StreamHandler) error {
+	ctx := stream.Context()
 
-	err := stream.SendHeader(md)
+	principal, err := authenticate(ctx, authenticator)
+	if err != nil {
+		return err
+	}
+
+	// only the client ID goes back, the request metadata holds the credentials
+	err = stream.SendHeader(metadata.Pairs(clientId.String(), principal.ID))
 	if err != nil {
 		return status.Errorf(codes.Internal, "error sending client ID")
 	}
 
-	return handler(srv, stream)
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: NewContext(ctx, principal)})
 }
 
-func valid(authorization []string) (bool, string) {
-	if len(authorization) < 1 {
-		return false, ""
+func validateUnaryToken(ctx context.Context, authenticator Authenticator, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	principal, err := authenticate(ctx, authenticator)
+	if err != nil {
+		return nil, err
 	}
 
-	token := strings.TrimPrefix(authorization[0], "Bearer ")
+	return handler(NewContext(ctx, principal), req)
+}
 
-	resp, err := http.PostForm(introspection, url.Values{"token": {token}})
-	if err != nil {
-		log.Errorf("error when getting introspection response: %v", err)
-		return false, ""
-	}
+// authenticate checks the credentials of the request and records the decision.
+func authenticate(ctx context.Context, authenticator Authenticator) (Principal, error) {
+	kind, secret := sentCredentials(ctx)
 
-	defer resp.Body.Close()
+	spanCtx, span := tracer.Start(ctx, "authenticate", trace.WithAttributes(label.String("credential_type", kind)))
+	event := audit.Event{Peer: audit.Peer(ctx), Credential: audit.Fingerprint(secret), Fields: map[string]interface{}{"credential_type": kind}}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	principal, err := authenticator.Authenticate(spanCtx)
 	if err != nil {
-		log.Errorf("error when reading introspection response: %v", err)
-		return false, ""
+		tracing.Fail(span, err)
 	}
+	span.End()
 
-	var introspection Introspection
-	err = json.Unmarshal(body, &introspection)
+	if err == ErrNoCredentials {
+		authentications.WithLabelValues("missing").Inc()
+		event.Type, event.Reason = audit.AuthFailure, "missing credentials"
+		audit.Record(event)
+		return Principal{}, status.Errorf(codes.Unauthenticated, "missing credentials")
+	}
 	if err != nil {
-		log.Errorf("error when unmarshalling introspection response %v", err)
-		return false, ""
+		log.Error(err)
+		authentications.WithLabelValues("invalid").Inc()
+		event.Type, event.Reason = audit.AuthFailure, Redact(err.Error(), secret)
+		audit.Record(event)
+		return Principal{}, status.Errorf(codes.Unauthenticated, "invalid credentials")
 	}
 
-	if !introspection.Active {
-		log.Errorf("token is not active (expired or revoked)")
-		return false, ""
+	log.Infof("authenticated client %v", principal.ID)
+	authentications.WithLabelValues("success").Inc()
+
+	event.Type, event.ClientId = audit.AuthSuccess, principal.ID
+	event.Fields["scopes"], event.Fields["tier"] = principal.Scopes, principal.Tier
+	if !principal.ExpiresAt.IsZero() {
+		event.Fields["expires_at"] = principal.ExpiresAt
 	}
+	audit.Record(event)
+
+	return principal, nil
+}
 
-	fmt.Println(introspection)
-	fmt.Printf("user %v authorized\n", introspection.Sub)
+// sentCredentials returns the kind of credentials sent with the request and the secret part of them,
+// used to fingerprint the credentials in the audit log.
+func sentCredentials(ctx context.Context) (string, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if authorization := md["authorization"]; len(authorization) > 0 {
+		return "token", strings.TrimPrefix(authorization[0], "Bearer ")
+	}
+
+	if key := md["x-api-key"]; len(key) > 0 {
+		return "apikey", key[0]
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			return "mtls", ""
+		}
+	}
+
+	return "none", ""
+}
+
+// Redact hides the secret wherever it shows up in the message.
+func Redact(message string, secret string) string {
+	if secret == "" {
+		return message
+	}
 
-	return introspection.Active, introspection.Sub
+	return strings.ReplaceAll(message, secret, "[redacted]")
 }
@@ -0,0 +1,169 @@
+package authentication
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type authenticatorFunc func(ctx context.Context) (Principal, error)
+
+func (f authenticatorFunc) Authenticate(ctx context.Context) (Principal, error) {
+	return f(ctx)
+}
+
+func TestChain(t *testing.T) {
+	none := authenticatorFunc(func(context.Context) (Principal, error) { return Principal{}, ErrNoCredentials })
+	first := authenticatorFunc(func(context.Context) (Principal, error) { return Principal{ID: "first"}, nil })
+	second := authenticatorFunc(func(context.Context) (Principal, error) { return Principal{ID: "second"}, nil })
+
+	principal, err := Chain{none, first, second}.Authenticate(context.Background())
+	if err != nil || principal.ID != "first" {
+		t.Errorf("first authenticator with credentials should win, got %v %v", principal, err)
+	}
+
+	_, err = Chain{none}.Authenticate(context.Background())
+	if err != ErrNoCredentials {
+		t.Errorf("chain without credentials should return ErrNoCredentials, got %v", err)
+	}
+}
+
+func TestChain_InvalidCredentialsStop(t *testing.T) {
+	invalid := authenticatorFunc(func(context.Context) (Principal, error) { return Principal{}, errors.New("invalid") })
+	valid := authenticatorFunc(func(context.Context) (Principal, error) { return Principal{ID: "valid"}, nil })
+
+	_, err := Chain{invalid, valid}.Authenticate(context.Background())
+	if err == nil {
+		t.Error("invalid credentials should not fall through to the next authenticator")
+	}
+}
+
+func TestNewUnaryAuthInterceptor(t *testing.T) {
+	first := NewUnaryAuthInterceptor(authenticatorFunc(func(context.Context) (Principal, error) { return Principal{ID: "first"}, nil }))
+	second := NewUnaryAuthInterceptor(authenticatorFunc(func(context.Context) (Principal, error) { return Principal{ID: "second"}, nil }))
+
+	handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return GetClientId(ctx)
+	}
+
+	// each interceptor keeps its own authenticator, creating the second one doesn't replace the first
+	for expected, interceptor := range map[string]grpc.UnaryServerInterceptor{"first": first, "second": second} {
+		id, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+		if err != nil || id != expected {
+			t.Errorf("expected client %v, got %v %v", expected, id, err)
+		}
+	}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	err := ioutil.WriteFile(path, []byte(`[{"key": "secret", "owner": "partner", "scopes": ["flights:read"], "tier": "partner"}]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := NewAPIKeyAuthenticator(APIKeyFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "secret"))
+	principal, err := authenticator.Authenticate(ctx)
+	if err != nil || principal.ID != "partner" || principal.Tier != "partner" {
+		t.Errorf("unexpected principal: %v %v", principal, err)
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "wrong"))
+	_, err = authenticator.Authenticate(ctx)
+	if err == nil || err == ErrNoCredentials {
+		t.Errorf("unknown key should be invalid, got %v", err)
+	}
+
+	_, err = authenticator.Authenticate(context.Background())
+	if err != ErrNoCredentials {
+		t.Errorf("missing key should return ErrNoCredentials, got %v", err)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://nearbyflights/receiver")
+
+	tests := map[string]*x509.Certificate{
+		"spiffe://nearbyflights/receiver": {URIs: []*url.URL{spiffe}, DNSNames: []string{"receiver.local"}},
+		"receiver.local":                  {DNSNames: []string{"receiver.local"}, Subject: pkix.Name{CommonName: "receiver"}},
+		"receiver":                        {Subject: pkix.Name{CommonName: "receiver"}},
+	}
+
+	for expected, certificate := range tests {
+		if id := identity(certificate); id != expected {
+			t.Errorf("expected identity %s, got %s", expected, id)
+		}
+	}
+}
+
+type trackedKeys struct {
+	keys    []APIKey
+	batches [][]string
+	err     error
+}
+
+func (t *trackedKeys) LoadAPIKeys() ([]APIKey, error) {
+	return t.keys, nil
+}
+
+func (t *trackedKeys) APIKeysUsed(hashes []string) error {
+	if t.err != nil {
+		return t.err
+	}
+
+	t.batches = append(t.batches, hashes)
+	return nil
+}
+
+func TestAPIKeyAuthenticator_Tracker(t *testing.T) {
+	key, keyHash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &trackedKeys{keys: []APIKey{{Hash: keyHash, Owner: "partner"}}, err: errors.New("database down")}
+
+	authenticator, err := NewAPIKeyAuthenticator(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", key))
+	for i := 0; i < 3; i++ {
+		principal, err := authenticator.Authenticate(ctx)
+		if err != nil || principal.ID != "partner" {
+			t.Fatalf("unexpected principal: %v %v", principal, err)
+		}
+	}
+
+	if err := authenticator.Flush(); err == nil {
+		t.Error("expected the error of the source")
+	}
+
+	// the failed batch is kept for the next flush
+	source.err = nil
+	if err := authenticator.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(source.batches) != 1 || len(source.batches[0]) != 1 || source.batches[0][0] != keyHash {
+		t.Errorf("expected the uses to be recorded in a single batch, got %v", source.batches)
+	}
+
+	if err := authenticator.Flush(); err != nil || len(source.batches) != 1 {
+		t.Errorf("expected nothing to record without new uses, got %v %v", source.batches, err)
+	}
+}
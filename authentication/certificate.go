@@ -0,0 +1,43 @@
+package authentication
+
+import (
+	"context"
+	"crypto/x509"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// CertificateAuthenticator authenticates clients by the TLS certificate they presented, which the
+// server must have verified against its client CA. Every certificate client gets the same scopes and tier.
+type CertificateAuthenticator struct {
+	Scopes []string
+	Tier   string
+}
+
+func (a CertificateAuthenticator) Authenticate(ctx context.Context) (Principal, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Principal{}, ErrNoCredentials
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) < 1 || len(info.State.VerifiedChains[0]) < 1 {
+		return Principal{}, ErrNoCredentials
+	}
+
+	return Principal{ID: identity(info.State.VerifiedChains[0][0]), Scopes: a.Scopes, Tier: a.Tier}, nil
+}
+
+// identity prefers a URI SAN (such as a SPIFFE ID), then a DNS SAN and finally the subject common name.
+func identity(certificate *x509.Certificate) string {
+	if len(certificate.URIs) > 0 {
+		return certificate.URIs[0].String()
+	}
+
+	if len(certificate.DNSNames) > 0 {
+		return certificate.DNSNames[0]
+	}
+
+	return certificate.Subject.CommonName
+}
@@ -0,0 +1,144 @@
+package authentication
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the client credentials token is renewed this long before it expires
+const tokenExpiryMargin = time.Second * 30
+
+// ClientAuth is how the server authenticates itself to the introspection endpoint.
+type ClientAuth struct {
+	// Method is none, basic, bearer or client_credentials.
+	Method       string
+	ClientId     string
+	ClientSecret string
+	// BearerToken is a static token used by the bearer method.
+	BearerToken string
+	// TokenUrl and Scopes are used by the client_credentials method to obtain tokens.
+	TokenUrl string
+	Scopes   []string
+	// TokenLifetime is assumed for tokens issued without expires_in.
+	TokenLifetime time.Duration
+}
+
+// newHTTPClient trusts the CA bundle in caFile on top of the system roots when it is set.
+func newHTTPClient(timeout time.Duration, caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return &http.Client{Timeout: timeout}, nil
+	}
+
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// clientCredentials obtains access tokens with the OAuth2 client credentials grant and keeps
+// the current one until shortly before it expires.
+type clientCredentials struct {
+	auth    ClientAuth
+	client  *http.Client
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (c *clientCredentials) Token(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.auth.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.auth.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.auth.ClientId), url.QueryEscape(c.auth.ClientSecret))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error when getting client credentials token: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error when reading client credentials token: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected client credentials token response status: %v", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", fmt.Errorf("error when unmarshalling client credentials token: %v", err)
+	}
+
+	if token.AccessToken == "" {
+		return "", errors.New("client credentials response without an access token")
+	}
+
+	lifetime := time.Duration(token.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = c.auth.TokenLifetime
+	}
+
+	// short-lived tokens are renewed halfway instead, so they are still used at least once
+	margin := tokenExpiryMargin
+	if margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(lifetime - margin)
+
+	return c.token, nil
+}
+
+// Invalidate drops the current token, the next call to Token obtains a new one.
+func (c *clientCredentials) Invalidate() {
+	c.mutex.Lock()
+	c.token = ""
+	c.mutex.Unlock()
+}
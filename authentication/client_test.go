@@ -0,0 +1,41 @@
+package authentication
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientCredentials_Lifetime(t *testing.T) {
+	tests := map[string]string{
+		"missing expires_in": `{"access_token": "admin-%v"}`,
+		"zero expires_in":    `{"access_token": "admin-%v", "expires_in": 0}`,
+		"short expires_in":   `{"access_token": "admin-%v", "expires_in": 10}`,
+	}
+
+	for name, response := range tests {
+		var tokens int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, response, atomic.AddInt32(&tokens, 1))
+		}))
+
+		c := &clientCredentials{auth: ClientAuth{TokenUrl: ts.URL, TokenLifetime: time.Minute}, client: ts.Client()}
+
+		for i := 0; i < 3; i++ {
+			token, err := c.Token(context.Background())
+			if err != nil || token != "admin-1" {
+				t.Errorf("%s: expected the first token to be reused, got %v %v", name, token, err)
+			}
+		}
+
+		if tokens != 1 {
+			t.Errorf("%s: expected a single token request, got %v", name, tokens)
+		}
+
+		ts.Close()
+	}
+}
@@ -0,0 +1,27 @@
+package authentication
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principal, p)
+}
+
+// FromContext returns the principal authenticated by the interceptor for the stream owning the context.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principal).(Principal)
+	return p, ok
+}
+
+// authenticatedStream carries the principal in the context seen by stream handlers.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -7,5 +7,7 @@ func (c contextKey) String() string {
 }
 
 var (
-	clientId = contextKey("client-id")
+	clientId  = contextKey("client-id")
+	principal = contextKey("principal")
+	noCache   = contextKey("no-cache")
 )
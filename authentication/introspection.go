@@ -0,0 +1,309 @@
+package authentication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+type Introspection struct {
+	Active     bool   `json:"active"`
+	ClientId   string `json:"client_id"`
+	Expiration int    `json:"exp"`
+	Iat        int    `json:"iat"`
+	Issuer     string `json:"iss"`
+	Scope      string `json:"scope"`
+	Sub        string `json:"sub"`
+	TokenType  string `json:"token_type"`
+}
+
+type IntrospectorOptions struct {
+	Url string
+	// Timeout bounds a whole introspection request.
+	Timeout time.Duration
+	// CacheTTL is the longest an active token is cached, tokens expiring sooner are cached until they expire.
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long an inactive token is cached.
+	NegativeCacheTTL time.Duration
+	// CAFile is a PEM bundle trusted on top of the system roots, for endpoints behind a private CA.
+	CAFile string
+	Auth   ClientAuth
+	// Retries is the number of extra attempts after a network error or a 5xx response.
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// Introspector asks an OAuth2 introspection endpoint whether a token is active and caches the answer.
+type Introspector struct {
+	options     IntrospectorOptions
+	client      *http.Client
+	credentials *clientCredentials
+	group       singleflight.Group
+	mutex       sync.Mutex
+	cache       map[string]cacheEntry
+	swept       time.Time
+}
+
+type cacheEntry struct {
+	introspection Introspection
+	expires       time.Time
+}
+
+func NewIntrospector(options IntrospectorOptions) (*Introspector, error) {
+	client, err := newHTTPClient(options.Timeout, options.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Introspector{
+		options: options,
+		client:  client,
+		cache:   make(map[string]cacheEntry),
+	}
+
+	switch options.Auth.Method {
+	case "", "none", "basic", "bearer":
+	case "client_credentials":
+		i.credentials = &clientCredentials{auth: options.Auth, client: client}
+	default:
+		return nil, fmt.Errorf("unknown introspection auth method %q", options.Auth.Method)
+	}
+
+	return i, nil
+}
+
+// Introspect returns the cached introspection of the token or asks the endpoint for it, concurrent
+// calls for the same token share a single request. The request doesn't belong to any of them, so a
+// caller going away doesn't fail the others, and each call stops waiting when its own context is done. Contexts from WithoutCache skip the cache.
+// Errors mean the endpoint couldn't answer, an invalid token is an inactive introspection.
+func (i *Introspector) Introspect(ctx context.Context, token string) (Introspection, error) {
+	key := hash(token)
+
+	if cacheAllowed(ctx) {
+		if introspection, ok := i.cached(key); ok {
+			introspectionCache.WithLabelValues("hit").Inc()
+			return introspection, nil
+		}
+
+		introspectionCache.WithLabelValues("miss").Inc()
+	}
+
+	results := i.group.DoChan(key, func() (interface{}, error) {
+		shared, cancel := i.sharedContext(ctx)
+		defer cancel()
+
+		start := time.Now()
+		introspection, err := i.request(shared, token)
+		introspectionDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			introspectionErrors.Inc()
+			return Introspection{}, err
+		}
+
+		i.store(key, introspection)
+
+		return introspection, nil
+	})
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return Introspection{}, unavailableError{result.Err}
+		}
+
+		return result.Val.(Introspection), nil
+	case <-ctx.Done():
+		return Introspection{}, unavailableError{ctx.Err()}
+	}
+}
+
+// sharedContext keeps the trace of the first caller but none of its cancellation, and lasts as long
+// as every attempt of a request may take.
+func (i *Introspector) sharedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	shared := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	if i.options.Timeout <= 0 {
+		return context.WithCancel(shared)
+	}
+
+	retries := time.Duration(i.options.Retries)
+	limit := (retries+1)*i.options.Timeout + retries*(retries+1)/2*i.options.RetryBackoff
+
+	return context.WithTimeout(shared, limit)
+}
+
+// request retries network errors and 5xx responses, and obtains a new client credentials token
+// once if the endpoint rejects the current one.
+func (i *Introspector) request(ctx context.Context, token string) (Introspection, error) {
+	renewed := false
+
+	for attempt := 0; ; attempt++ {
+		introspection, status, err := i.post(ctx, token)
+		if err == nil {
+			return introspection, nil
+		}
+
+		if status == http.StatusUnauthorized && i.credentials != nil && !renewed {
+			i.credentials.Invalidate()
+			renewed = true
+			continue
+		}
+
+		if attempt >= i.options.Retries || (status != 0 && status < http.StatusInternalServerError) {
+			return Introspection{}, err
+		}
+
+		backoff := time.NewTimer(i.options.RetryBackoff * time.Duration(attempt+1))
+		select {
+		case <-backoff.C:
+		case <-ctx.Done():
+			backoff.Stop()
+			return Introspection{}, err
+		}
+	}
+}
+
+func (i *Introspector) post(ctx context.Context, token string) (Introspection, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.options.Url, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return Introspection{}, 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	err = i.authorize(req)
+	if err != nil {
+		return Introspection{}, 0, err
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return Introspection{}, 0, fmt.Errorf("error when getting introspection response: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Introspection{}, 0, fmt.Errorf("error when reading introspection response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Introspection{}, resp.StatusCode, fmt.Errorf("unexpected introspection response status: %v", resp.Status)
+	}
+
+	var introspection Introspection
+	err = json.Unmarshal(body, &introspection)
+	if err != nil {
+		return Introspection{}, resp.StatusCode, fmt.Errorf("error when unmarshalling introspection response %v", err)
+	}
+
+	return introspection, resp.StatusCode, nil
+}
+
+func (i *Introspector) authorize(req *http.Request) error {
+	switch i.options.Auth.Method {
+	case "basic":
+		req.SetBasicAuth(url.QueryEscape(i.options.Auth.ClientId), url.QueryEscape(i.options.Auth.ClientSecret))
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+i.options.Auth.BearerToken)
+	case "client_credentials":
+		token, err := i.credentials.Token(req.Context())
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return nil
+}
+
+func (i *Introspector) cached(key string) (Introspection, bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	entry, ok := i.cache[key]
+	if !ok {
+		return Introspection{}, false
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(i.cache, key)
+		return Introspection{}, false
+	}
+
+	return entry.introspection, true
+}
+
+func (i *Introspector) store(key string, introspection Introspection) {
+	now := time.Now()
+
+	ttl := i.options.NegativeCacheTTL
+	if introspection.Active {
+		ttl = i.options.CacheTTL
+
+		if introspection.Expiration > 0 {
+			untilExpiration := time.Unix(int64(introspection.Expiration), 0).Sub(now)
+			if untilExpiration < ttl {
+				ttl = untilExpiration
+			}
+		}
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if now.Sub(i.swept) > time.Minute {
+		for k, entry := range i.cache {
+			if now.After(entry.expires) {
+				delete(i.cache, k)
+			}
+		}
+
+		i.swept = now
+	}
+
+	i.cache[key] = cacheEntry{introspection: introspection, expires: now.Add(ttl)}
+}
+
+// hash keeps raw tokens out of memory held by the cache.
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Ping checks that the introspection endpoint is reachable, any response but a server error will do.
+func (i *Introspector) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, i.options.Url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected introspection endpoint status: %v", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package authentication
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newIntrospectionServer(active bool, exp int64, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		time.Sleep(time.Millisecond * 10)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"active": %v, "exp": %v, "sub": "my-client"}`, active, exp)
+	}))
+}
+
+func TestIntrospect_Cached(t *testing.T) {
+	var requests int32
+	ts := newIntrospectionServer(true, time.Now().Add(time.Hour).Unix(), &requests)
+	defer ts.Close()
+
+	introspector, _ := NewIntrospector(IntrospectorOptions{Url: ts.URL, Timeout: time.Second, CacheTTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		introspection, err := introspector.Introspect(context.Background(), "token")
+		if err != nil || !introspection.Active {
+			t.Fatalf("token should be active: %v", err)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("token should be introspected once, got %v requests", requests)
+	}
+}
+
+func TestIntrospect_Concurrent(t *testing.T) {
+	var requests int32
+	ts := newIntrospectionServer(true, time.Now().Add(time.Hour).Unix(), &requests)
+	defer ts.Close()
+
+	introspector, _ := NewIntrospector(IntrospectorOptions{Url: ts.URL, Timeout: time.Second, CacheTTL: time.Minute})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = introspector.Introspect(context.Background(), "token")
+		}()
+	}
+	wg.Wait()
+
+	if requests != 1 {
+		t.Errorf("concurrent introspections should share one request, got %v requests", requests)
+	}
+}
+
+func TestIntrospect_Expired(t *testing.T) {
+	var requests int32
+	ts := newIntrospectionServer(true, time.Now().Add(-time.Hour).Unix(), &requests)
+	defer ts.Close()
+
+	introspector, _ := NewIntrospector(IntrospectorOptions{Url: ts.URL, Timeout: time.Second, CacheTTL: time.Minute})
+
+	_, _ = introspector.Introspect(context.Background(), "token")
+	_, _ = introspector.Introspect(context.Background(), "token")
+
+	if requests != 2 {
+		t.Errorf("token past its expiration should not be cached, got %v requests", requests)
+	}
+}
+
+func TestIntrospect_NegativeCached(t *testing.T) {
+	var requests int32
+	ts := newIntrospectionServer(false, 0, &requests)
+	defer ts.Close()
+
+	introspector, _ := NewIntrospector(IntrospectorOptions{Url: ts.URL, Timeout: time.Second, CacheTTL: time.Minute, NegativeCacheTTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		introspection, _ := introspector.Introspect(context.Background(), "token")
+		if introspection.Active {
+			t.Fatal("token should not be active")
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("inactive token should be introspected once, got %v requests", requests)
+	}
+}
+
+func TestIntrospect_ClientCredentials(t *testing.T) {
+	var tokens int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/oauth2/token":
+			id, secret, _ := r.BasicAuth()
+			if id != "nearbyflights" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			n := atomic.AddInt32(&tokens, 1)
+			fmt.Fprintf(w, `{"access_token": "admin-%v", "expires_in": 3600}`, n)
+		case "/oauth2/introspect":
+			// the first admin token is treated as revoked to force a renewal
+			if r.Header.Get("Authorization") != "Bearer admin-2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			fmt.Fprint(w, `{"active": true, "sub": "my-client"}`)
+		}
+	}))
+	defer ts.Close()
+
+	introspector, err := NewIntrospector(IntrospectorOptions{
+		Url:     ts.URL + "/oauth2/introspect",
+		Timeout: time.Second,
+		Auth:    ClientAuth{Method: "client_credentials", ClientId: "nearbyflights", ClientSecret: "secret", TokenUrl: ts.URL + "/oauth2/token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	introspection, err := introspector.Introspect(context.Background(), "token")
+	if err != nil || !introspection.Active {
+		t.Fatalf("token should be active: %v", err)
+	}
+
+	if tokens != 2 {
+		t.Errorf("rejected admin token should be renewed once, got %v tokens", tokens)
+	}
+}
+
+func TestIntrospect_Retries(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprint(w, `{"active": true, "sub": "my-client"}`)
+	}))
+	defer ts.Close()
+
+	introspector, _ := NewIntrospector(IntrospectorOptions{Url: ts.URL, Timeout: time.Second, Retries: 2, RetryBackoff: time.Millisecond})
+
+	introspection, err := introspector.Introspect(context.Background(), "token")
+	if err != nil || !introspection.Active {
+		t.Errorf("token should be active after retrying: %v", err)
+	}
+}
+
+func TestIntrospect_Canceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	introspector, _ := NewIntrospector(IntrospectorOptions{Url: ts.URL, Timeout: time.Second, Retries: 5, RetryBackoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := introspector.Introspect(ctx, "token")
+	if err == nil {
+		t.Error("expected an error from the failing endpoint")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the retry backoff should stop when the context is done, took %v", elapsed)
+	}
+}
+
+func TestIntrospect_FirstCallerCanceled(t *testing.T) {
+	var requests int32
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		received <- struct{}{}
+		<-release
+
+		fmt.Fprintf(w, `{"active": true, "exp": %v, "sub": "my-client"}`, time.Now().Add(time.Hour).Unix())
+	}))
+	defer ts.Close()
+
+	introspector, _ := NewIntrospector(IntrospectorOptions{Url: ts.URL, Timeout: 5 * time.Second})
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := introspector.Introspect(first, "token")
+		firstErr <- err
+	}()
+
+	<-received
+
+	type result struct {
+		introspection Introspection
+		err           error
+	}
+	second := make(chan result, 1)
+	go func() {
+		introspection, err := introspector.Introspect(context.Background(), "token")
+		second <- result{introspection, err}
+	}()
+
+	// the second caller joins the request of the first one, which then goes away
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := <-firstErr; err == nil || !IsUnavailable(err) {
+		t.Errorf("expected the canceled caller to stop waiting, got %v", err)
+	}
+
+	close(release)
+
+	r := <-second
+	if r.err != nil || !r.introspection.Active {
+		t.Errorf("expected the second caller to get the introspection, got %+v %v", r.introspection, r.err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected a single shared request, got %v", n)
+	}
+}
@@ -0,0 +1,367 @@
+package authentication
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// unknown key IDs trigger a refresh of the key set at most this often
+const minRefreshInterval = time.Second * 30
+
+type JWTOptions struct {
+	JWKSUrl         string
+	RefreshInterval time.Duration
+	Timeout         time.Duration
+	// Issuer and Audience are the expected iss and aud claims, both are required.
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew tolerated when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// JWTValidator verifies signed JWT access tokens locally with the keys published at a JWKS endpoint.
+type JWTValidator struct {
+	options   JWTOptions
+	client    *http.Client
+	group     singleflight.Group
+	mutex     sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	refreshed time.Time
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	Expires   int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+	IssuedAt  int64           `json:"iat"`
+	ClientId  string          `json:"client_id"`
+	Scope     string          `json:"scope"`
+	Scopes    []string        `json:"scp"`
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyId   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func NewJWTValidator(options JWTOptions) *JWTValidator {
+	return &JWTValidator{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		keys:    make(map[string]crypto.PublicKey),
+	}
+}
+
+// Run refreshes the key set every refresh interval until the context is done.
+func (v *JWTValidator) Run(ctx context.Context) {
+	ticker := time.NewTicker(v.options.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := v.Refresh()
+			if err != nil {
+				log.Errorf("error refreshing JWKS, keeping the previous keys: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (v *JWTValidator) Refresh() error {
+	resp, err := v.client.Get(v.options.JWKSUrl)
+	if err != nil {
+		return fmt.Errorf("error when getting JWKS: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error when reading JWKS: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected JWKS response status: %v", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.Unmarshal(body, &set)
+	if err != nil {
+		return fmt.Errorf("error when unmarshalling JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			log.Warnf("skipping JWKS key %q: %v", k.KeyId, err)
+			continue
+		}
+
+		keys[k.KeyId] = key
+	}
+
+	v.mutex.Lock()
+	v.keys = keys
+	v.refreshed = time.Now()
+	v.mutex.Unlock()
+
+	log.Infof("loaded %v key(s) from JWKS", len(keys))
+
+	return nil
+}
+
+// Introspect verifies the token signature and claims and returns them in the same shape as
+// an introspection response, so it can be used in place of an Introspector.
+func (v *JWTValidator) Introspect(_ context.Context, token string) (Introspection, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Introspection{}, errors.New("malformed JWT")
+	}
+
+	var header jwtHeader
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return Introspection{}, fmt.Errorf("error decoding JWT header: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Introspection{}, fmt.Errorf("error decoding JWT signature: %v", err)
+	}
+
+	key, err := v.key(header.KeyId)
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	err = verify(header.Algorithm, key, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	var claims jwtClaims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return Introspection{}, fmt.Errorf("error decoding JWT claims: %v", err)
+	}
+
+	err = v.check(claims)
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	scope := claims.Scope
+	if scope == "" {
+		scope = strings.Join(claims.Scopes, " ")
+	}
+
+	return Introspection{
+		Active:     true,
+		ClientId:   claims.ClientId,
+		Expiration: int(claims.Expires),
+		Iat:        int(claims.IssuedAt),
+		Issuer:     claims.Issuer,
+		Scope:      scope,
+		Sub:        claims.Subject,
+		TokenType:  "access_token",
+	}, nil
+}
+
+func (v *JWTValidator) key(id string) (crypto.PublicKey, error) {
+	v.mutex.RLock()
+	key, ok := v.keys[id]
+	refreshed := v.refreshed
+	v.mutex.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	// the key set may have been rotated since the last refresh
+	if time.Since(refreshed) < minRefreshInterval {
+		return nil, fmt.Errorf("unknown JWT key ID %q", id)
+	}
+
+	_, err, _ := v.group.Do("refresh", func() (interface{}, error) {
+		return nil, v.Refresh()
+	})
+	if err != nil {
+		return nil, unavailableError{err}
+	}
+
+	v.mutex.RLock()
+	key, ok = v.keys[id]
+	v.mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown JWT key ID %q", id)
+	}
+
+	return key, nil
+}
+
+func (v *JWTValidator) check(claims jwtClaims) error {
+	now := time.Now()
+
+	if claims.Expires == 0 || now.After(time.Unix(claims.Expires, 0).Add(v.options.Leeway)) {
+		return errors.New("JWT expired")
+	}
+
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-v.options.Leeway)) {
+		return errors.New("JWT not valid yet")
+	}
+
+	if claims.Issuer != v.options.Issuer {
+		return fmt.Errorf("unexpected JWT issuer %q", claims.Issuer)
+	}
+
+	if !containsAudience(claims.Audience, v.options.Audience) {
+		return errors.New("JWT not issued for this audience")
+	}
+
+	return nil
+}
+
+// containsAudience accepts the aud claim both as a single string and as an array of strings.
+func containsAudience(raw json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == audience
+	}
+
+	var multiple []string
+	if json.Unmarshal(raw, &multiple) == nil {
+		for _, a := range multiple {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func verify(algorithm string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch algorithm {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("JWT key is not an RSA key")
+		}
+
+		err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature)
+		if err != nil {
+			return errors.New("invalid JWT signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("JWT key is not an EC key")
+		}
+
+		if len(signature) != 64 {
+			return errors.New("invalid JWT signature")
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return errors.New("invalid JWT signature")
+		}
+	default:
+		return fmt.Errorf("unsupported JWT algorithm %q", algorithm)
+	}
+
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
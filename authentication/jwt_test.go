@@ -0,0 +1,140 @@
+package authentication
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newJWKSServer is a local stand-in for the authorization server JWKS endpoint.
+func newJWKSServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string][]jwk{"keys": {
+			{KeyType: "RSA", KeyId: "rsa", Use: "sig", N: encode(rsaKey.N.Bytes()), E: encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{KeyType: "EC", KeyId: "ec", Use: "sig", Curve: "P-256", X: encode(ecKey.X.Bytes()), Y: encode(ecKey.Y.Bytes())},
+		}})
+	}))
+}
+
+func sign(t *testing.T, algorithm string, keyId string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(jwtHeader{Algorithm: algorithm, KeyId: keyId})
+	payload, _ := json.Marshal(claims)
+	signed := encode(header) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch algorithm {
+	case "RS256":
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return signed + "." + encode(signature)
+}
+
+func newValidator(t *testing.T) *JWTValidator {
+	ts := newJWKSServer()
+	t.Cleanup(ts.Close)
+
+	validator := NewJWTValidator(JWTOptions{JWKSUrl: ts.URL, Timeout: time.Second, Issuer: "http://127.0.0.1:4444/", Audience: "nearbyflights"})
+
+	err := validator.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return validator
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   "http://127.0.0.1:4444/",
+		"aud":   []string{"nearbyflights"},
+		"sub":   "my-client",
+		"scope": "flights:read",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nbf":   time.Now().Add(-time.Minute).Unix(),
+	}
+}
+
+func TestIntrospect_JWT(t *testing.T) {
+	validator := newValidator(t)
+
+	for _, algorithm := range []struct{ name, kid string }{{"RS256", "rsa"}, {"ES256", "ec"}} {
+		introspection, err := validator.Introspect(context.Background(), sign(t, algorithm.name, algorithm.kid, validClaims()))
+		if err != nil {
+			t.Fatalf("%s token should be valid: %v", algorithm.name, err)
+		}
+
+		if !introspection.Active || introspection.Sub != "my-client" || introspection.Scope != "flights:read" {
+			t.Errorf("unexpected introspection for %s token: %v", algorithm.name, introspection)
+		}
+	}
+}
+
+func TestIntrospect_JWTInvalidClaims(t *testing.T) {
+	validator := newValidator(t)
+
+	tests := map[string]func(claims map[string]interface{}){
+		"expired":        func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"not before":     func(claims map[string]interface{}) { claims["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"wrong issuer":   func(claims map[string]interface{}) { claims["iss"] = "http://evil/" },
+		"wrong audience": func(claims map[string]interface{}) { claims["aud"] = "other" },
+		"no issuer":      func(claims map[string]interface{}) { delete(claims, "iss") },
+		"no audience":    func(claims map[string]interface{}) { delete(claims, "aud") },
+	}
+
+	for name, change := range tests {
+		claims := validClaims()
+		change(claims)
+
+		_, err := validator.Introspect(context.Background(), sign(t, "RS256", "rsa", claims))
+		if err == nil {
+			t.Errorf("%s token should not be valid", name)
+		}
+	}
+}
+
+func TestIntrospect_JWTTampered(t *testing.T) {
+	validator := newValidator(t)
+
+	// a token signed by the RSA key but claiming to be signed by the EC key
+	_, err := validator.Introspect(context.Background(), sign(t, "RS256", "ec", validClaims()))
+	if err == nil {
+		t.Error("token with a mismatched key should not be valid")
+	}
+
+	_, err = validator.Introspect(context.Background(), sign(t, "none", "rsa", validClaims()))
+	if err == nil {
+		t.Error("unsigned token should not be valid")
+	}
+}
@@ -0,0 +1,29 @@
+package authentication
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	authentications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nearbyflights_authentications_total",
+		Help: "Stream authentications by result.",
+	}, []string{"result"})
+
+	introspectionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "nearbyflights_introspection_duration_seconds",
+		Help:    "Duration of token introspection requests, retries included.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	introspectionErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nearbyflights_introspection_errors_total",
+		Help: "Token introspection requests that failed after every retry.",
+	})
+
+	introspectionCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nearbyflights_introspection_cache_total",
+		Help: "Token introspection cache lookups by result.",
+	}, []string{"result"})
+)
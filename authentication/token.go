@@ -0,0 +1,90 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/tracing"
+	"go.opentelemetry.io/otel/label"
+	"google.golang.org/grpc/metadata"
+)
+
+// TokenIntrospector tells whether an access token is active, either by asking the authorization
+// server (Introspector) or by verifying it locally (JWTValidator).
+type TokenIntrospector interface {
+	Introspect(ctx context.Context, token string) (Introspection, error)
+}
+
+// TokenAuthenticator authenticates OAuth2 bearer tokens sent in the authorization header.
+type TokenAuthenticator struct {
+	Introspector TokenIntrospector
+}
+
+func (a TokenAuthenticator) Authenticate(ctx context.Context) (Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	authorization := md["authorization"]
+	if len(authorization) < 1 || !strings.HasPrefix(authorization[0], "Bearer ") {
+		return Principal{}, ErrNoCredentials
+	}
+
+	token := strings.TrimPrefix(authorization[0], "Bearer ")
+
+	ctx, span := tracer.Start(ctx, "introspect")
+	introspection, err := a.Introspector.Introspect(ctx, token)
+	if err != nil {
+		tracing.Fail(span, err)
+		span.End()
+		return Principal{}, err
+	}
+
+	span.SetAttributes(label.Bool("active", introspection.Active))
+	span.End()
+
+	if !introspection.Active {
+		return Principal{}, errors.New("token is not active (expired or revoked)")
+	}
+
+	principal := Principal{ID: introspection.Sub, Scopes: strings.Fields(introspection.Scope)}
+	if introspection.Expiration > 0 {
+		principal.ExpiresAt = time.Unix(int64(introspection.Expiration), 0)
+	}
+
+	return principal, nil
+}
+
+// TokenFromContext returns the bearer token sent with the stream.
+func TokenFromContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	authorization := md["authorization"]
+	if len(authorization) < 1 {
+		return ""
+	}
+
+	return strings.TrimPrefix(authorization[0], "Bearer ")
+}
+
+// WithoutCache returns a copy of the context whose authentication asks the authorization server
+// again instead of using a cached answer.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCache, true)
+}
+
+func cacheAllowed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(noCache).(bool)
+	return !bypass
+}
+
+// WithToken returns a copy of the context whose metadata carries the given bearer token, so a
+// stream can be authenticated again with a token received after it started.
+func WithToken(ctx context.Context, token string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	md = md.Copy()
+	md.Set("authorization", "Bearer "+token)
+
+	return metadata.NewIncomingContext(ctx, md)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const certificateUsage = `usage: nearbyflights certificate [-hosts <host,...>] [-days <days>] [-force]
+
+Writes a self-signed certificate for local development to TLS_CERTIFICATE_PATH and its key to TLS_CERTIFICATE_KEY_PATH.`
+
+// certificate generates a self-signed development certificate and returns the exit code.
+func certificate(c Configuration, args []string) int {
+	flags := flag.NewFlagSet("certificate", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, certificateUsage) }
+	hosts := flags.String("hosts", "localhost,127.0.0.1", "comma separated host names and IP addresses of the certificate")
+	days := flags.Int("days", 365, "days the certificate is valid for")
+	force := flags.Bool("force", false, "overwrite existing files")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	if !*force {
+		for _, path := range []string{c.TlsCertificatePath, c.TlsCertificateKeyPath} {
+			if _, err := os.Stat(path); err == nil {
+				fmt.Fprintf(os.Stderr, "%s already exists, use -force to overwrite it\n", path)
+				return 1
+			}
+		}
+	}
+
+	certificate, key, err := selfSigned(splitList(*hosts), time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = writePEM(c.TlsCertificatePath, certificate, 0644)
+	if err == nil {
+		err = writePEM(c.TlsCertificateKeyPath, key, 0600)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("certificate written to %s and key to %s\n", c.TlsCertificatePath, c.TlsCertificateKeyPath)
+
+	return 0
+}
+
+// selfSigned returns a PEM encoded ECDSA certificate for the hosts and its PEM encoded private key.
+func selfSigned(hosts []string, validity time.Duration) ([]byte, []byte, error) {
+	if len(hosts) == 0 {
+		return nil, nil, fmt.Errorf("the certificate needs at least one host")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating serial number: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"nearbyflights development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		// the certificate signs itself, so clients can trust it as a CA
+		IsCA: true,
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating certificate: %v", err)
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encoding key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+func writePEM(path string, data []byte, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating the folder of %s: %v", path, err)
+	}
+
+	err = ioutil.WriteFile(path, data, mode)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+
+	return nil
+}
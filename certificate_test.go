@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSigned(t *testing.T) {
+	certificatePEM, keyPEM, err := selfSigned([]string{"localhost", "127.0.0.1", "flights.test"}, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(certificatePEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("expected a PEM certificate, got %q", certificatePEM)
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certificate.DNSNames) != 2 || certificate.DNSNames[0] != "localhost" || certificate.DNSNames[1] != "flights.test" {
+		t.Errorf("expected the host names as DNS SANs, got %v", certificate.DNSNames)
+	}
+	if len(certificate.IPAddresses) != 1 || !certificate.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("expected the IP address as an IP SAN, got %v", certificate.IPAddresses)
+	}
+	if certificate.Subject.CommonName != "localhost" {
+		t.Errorf("expected the first host as common name, got %v", certificate.Subject.CommonName)
+	}
+
+	if certificate.KeyUsage != x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign {
+		t.Errorf("expected digital signature and certificate signing key usages, got %v", certificate.KeyUsage)
+	}
+	if len(certificate.ExtKeyUsage) != 1 || certificate.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("expected the server authentication extended key usage, got %v", certificate.ExtKeyUsage)
+	}
+	if !certificate.IsCA {
+		t.Error("expected the certificate to be its own CA")
+	}
+
+	if certificate.NotBefore.After(time.Now()) {
+		t.Errorf("expected the certificate to be valid already, it starts at %v", certificate.NotBefore)
+	}
+	if expiry := time.Until(certificate.NotAfter); expiry < 47*time.Hour || expiry > 48*time.Hour {
+		t.Errorf("expected the certificate to expire in 48h, it expires in %v", expiry)
+	}
+
+	// the certificate verifies itself for each host
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	for _, host := range []string{"localhost", "127.0.0.1", "flights.test"} {
+		if _, err := certificate.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("expected the certificate to be valid for %s: %v", host, err)
+		}
+	}
+
+	block, _ = pem.Decode(keyPEM)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("expected a PEM private key, got %q", keyPEM)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.(*ecdsa.PrivateKey).PublicKey.Equal(certificate.PublicKey) {
+		t.Error("expected the key of the certificate")
+	}
+
+	if _, _, err := selfSigned(nil, time.Hour); err == nil {
+		t.Error("expected an error without hosts")
+	}
+}
+
+func TestRunCommand_CertificateWithoutDatabase(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"CONFIG_FILE", "STORAGE_BACKEND", "POSTGRES_PASSWORD"} {
+		unsetenv(t, name)
+	}
+	setenv(t, "TLS_CERTIFICATE_PATH", filepath.Join(dir, "server.crt"))
+	setenv(t, "TLS_CERTIFICATE_KEY_PATH", filepath.Join(dir, "server.key"))
+
+	c, err := readConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validate(c) == nil {
+		t.Fatal("expected the server to refuse a configuration without the database password")
+	}
+
+	code, ok := runCommand(c, []string{"certificate"})
+	if !ok || code != 0 {
+		t.Fatalf("expected the certificate command to succeed, got %v %v", code, ok)
+	}
+	for _, path := range []string{c.TlsCertificatePath, c.TlsCertificateKeyPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected the command to write %s: %v", path, err)
+		}
+	}
+
+	if code, ok := runCommand(c, []string{"keys", "list"}); !ok || code != 1 {
+		t.Errorf("expected the keys command to need the database settings, got %v %v", code, ok)
+	}
+	if _, ok := runCommand(c, nil); ok {
+		t.Error("expected the server to start without a command")
+	}
+}
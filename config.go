@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/nearbyflights/nearbyflights/limit"
+	"github.com/nearbyflights/nearbyflights/logging"
+	"github.com/nearbyflights/nearbyflights/schedule"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// loadConfiguration reads the YAML file in CONFIG_FILE, when set, and the environment, then validates
+// the result. The file holds the same settings as the environment variables, written in lower case:
+//
+//	storage_backend: embedded
+//	limit_max_streams: 20
+//	postgres_replica_urls: [replica-1:5432, replica-2:5432]
+//
+// Environment variables win over the file, which wins over the defaults.
+func loadConfiguration() (Configuration, error) {
+	c, err := readConfiguration()
+	if err != nil {
+		return c, err
+	}
+
+	return c, validate(c)
+}
+
+// readConfiguration reads the configuration like loadConfiguration, without validating it.
+func readConfiguration() (Configuration, error) {
+	var c Configuration
+
+	err := envconfig.Process("", &c)
+	if err != nil {
+		return c, err
+	}
+
+	if c.ConfigFile != "" {
+		values, err := readConfigurationFile(c.ConfigFile)
+		if err != nil {
+			return c, err
+		}
+
+		// envconfig already set the defaults and the environment, the file replaces the defaults
+		v := reflect.ValueOf(&c).Elem()
+		for i, f := range fields(c) {
+			value, ok := values[f.name]
+			if !ok {
+				continue
+			}
+			if _, ok := os.LookupEnv(f.name); ok {
+				continue
+			}
+
+			err = setField(v.Field(i), value)
+			if err != nil {
+				return c, fmt.Errorf("error reading %s from the configuration file: %v", strings.ToLower(f.name), err)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// readConfigurationFile returns the settings of the file by environment variable name.
+func readConfigurationFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the configuration file: %v", err)
+	}
+
+	var file map[string]setting
+	err = yaml.UnmarshalStrict(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the configuration file %s: %v", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, f := range fields(Configuration{}) {
+		known[f.name] = true
+	}
+
+	values := make(map[string]string, len(file))
+	for key, value := range file {
+		name := strings.ToUpper(key)
+		if !known[name] || name == "CONFIG_FILE" {
+			return nil, fmt.Errorf("unknown setting %q in %s", key, path)
+		}
+
+		values[name] = string(value)
+	}
+
+	return values, nil
+}
+
+// setting is a value of the configuration file as envconfig reads it from the environment. Scalars
+// keep their text, so off stays off instead of turning into false, and lists are joined by commas.
+type setting string
+
+func (s *setting) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var scalar string
+	if unmarshal(&scalar) == nil {
+		*s = setting(scalar)
+		return nil
+	}
+
+	var list []string
+	if unmarshal(&list) == nil {
+		*s = setting(strings.Join(list, ","))
+		return nil
+	}
+
+	return errors.New("settings must be a value or a list of values")
+}
+
+// setField parses a value of the configuration file into its field the way envconfig parses the environment.
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.ParseInt(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var list []string
+		if value != "" {
+			list = strings.Split(value, ",")
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %v", field.Type())
+	}
+
+	return nil
+}
+
+// validate checks the settings that would otherwise fail late, or not at all, and reports all of them at once.
+func validate(c Configuration) error {
+	var problems []string
+	check := func(ok bool, format string, a ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+
+	oneOf := func(name string, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+
+		check(false, "%s must be one of %s, not %q", name, strings.Join(allowed, ", "), value)
+	}
+
+	_, err := log.ParseLevel(c.LogLevel)
+	check(err == nil, "LOG_LEVEL %q isn't a log level", c.LogLevel)
+	oneOf("LOG_FORMAT", c.LogFormat, "text", "json")
+	oneOf("STORAGE_BACKEND", c.StorageBackend, "postgres", "embedded")
+	oneOf("TLS_MODE", c.TlsMode, "tls", "mtls", "off")
+	oneOf("AUTH_MODE", c.AuthMode, "introspection", "jwt")
+	oneOf("API_KEYS_SOURCE", c.APIKeysSource, "file", "database")
+	oneOf("INTROSPECTION_AUTH", c.IntrospectionAuth, "none", "basic", "bearer", "client_credentials")
+	oneOf("TRACING_EXPORTER", c.TracingExporter, "none", "stdout", "otlp")
+
+	check(len(c.Authenticators) > 0, "AUTHENTICATORS can't be empty")
+	for _, a := range c.Authenticators {
+		oneOf("AUTHENTICATORS", a, "token", "apikey", "mtls")
+	}
+
+	if c.StorageBackend == "postgres" || c.APIKeysSource == "database" {
+		check(c.Password != "", "POSTGRES_PASSWORD is required with PostgreSQL")
+	}
+	check(c.TlsMode != "mtls" || c.TlsClientCAPath != "", "TLS_MODE=mtls needs TLS_CLIENT_CA_PATH")
+	check(c.AuthMode != "jwt" || c.JWTIssuer != "", "AUTH_MODE=jwt needs JWT_ISSUER")
+	check(c.AuthMode != "jwt" || c.JWTAudience != "", "AUTH_MODE=jwt needs JWT_AUDIENCE")
+	check(c.ListenAddress != "" || c.UnixSocket != "", "LISTEN_ADDRESS or UNIX_SOCKET is required")
+
+	check(c.EmbeddedImportInterval > 0, "EMBEDDED_IMPORT_INTERVAL must be positive")
+	check(c.SchedulerWorkers > 0, "SCHEDULER_WORKERS must be positive")
+	check(c.DedupeWindow >= 0, "DEDUPE_WINDOW can't be negative")
+	check(c.LimitMaxStreams >= 0, "LIMIT_MAX_STREAMS can't be negative")
+	check(c.LimitMessageRate >= 0, "LIMIT_MESSAGE_RATE can't be negative")
+	check(c.LimitMessageBurst >= 0, "LIMIT_MESSAGE_BURST can't be negative")
+	check(c.HealthCheckInterval > 0, "HEALTH_CHECK_INTERVAL must be positive")
+	check(c.HealthCheckTimeout > 0, "HEALTH_CHECK_TIMEOUT must be positive")
+	check(c.FreshnessCheckInterval > 0, "FRESHNESS_CHECK_INTERVAL must be positive")
+	check(c.ShutdownDelay >= 0, "SHUTDOWN_DELAY can't be negative")
+	check(c.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+	check(c.IntrospectionTokenLifetime > 0, "INTROSPECTION_TOKEN_LIFETIME must be positive")
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// reloadOnHangup loads the configuration again on every SIGHUP and applies the settings tagged
+// reload. Other settings need a restart, changing them only logs a warning.
+func reloadOnHangup(ctx context.Context, current Configuration, limiter *limit.Limiter, scheduler *schedule.Scheduler) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-hangups:
+		case <-ctx.Done():
+			return
+		}
+
+		c, err := loadConfiguration()
+		if err != nil {
+			log.Errorf("error reloading the configuration, keeping the current one: %v", err)
+			continue
+		}
+
+		err = logging.Setup(logging.Options{Level: c.LogLevel, Format: c.LogFormat, SampleInterval: c.LogSampleInterval})
+		if err != nil {
+			log.Errorf("error reloading the log settings: %v", err)
+		}
+
+		limiter.SetOptions(limitOptions(c))
+		scheduler.SetDedupeWindow(c.DedupeWindow)
+
+		previous := fields(current)
+		for i, f := range fields(c) {
+			if !f.reload && !reflect.DeepEqual(f.value, previous[i].value) {
+				log.Warnf("%s changed, restart the server to apply it", f.name)
+			}
+		}
+
+		current = c
+
+		log.Info("configuration reloaded")
+	}
+}
+
+func limitOptions(c Configuration) limit.Options {
+	return limit.Options{MaxStreams: c.LimitMaxStreams, MessageRate: c.LimitMessageRate, MessageBurst: c.LimitMessageBurst}
+}
+
+// printConfiguration writes the effective configuration as a configuration file, with the secrets masked.
+func printConfiguration(c Configuration) int {
+	var out yaml.MapSlice
+	for _, f := range fields(c) {
+		value := f.value
+		switch v := value.(type) {
+		case time.Duration:
+			value = v.String()
+		case string:
+			if f.secret && v != "" {
+				value = "********"
+			}
+		}
+
+		out = append(out, yaml.MapItem{Key: strings.ToLower(f.name), Value: value})
+	}
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Print(string(data))
+
+	return 0
+}
+
+type field struct {
+	name   string
+	value  interface{}
+	reload bool
+	secret bool
+}
+
+// fields lists the settings of the configuration in the order they are declared.
+func fields(c Configuration) []field {
+	v := reflect.ValueOf(c)
+	t := v.Type()
+
+	list := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag
+		list = append(list, field{
+			name:   tag.Get("envconfig"),
+			value:  v.Field(i).Interface(),
+			reload: tag.Get("reload") == "true",
+			secret: tag.Get("secret") == "true",
+		})
+	}
+
+	return list
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/nearbyflights/nearbyflights/limit"
+	"github.com/nearbyflights/nearbyflights/schedule"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"gopkg.in/yaml.v2"
+)
+
+// setenv sets the environment variable for the test only.
+func setenv(t *testing.T, name string, value string) {
+	previous, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+// unsetenv removes the environment variable for the test only.
+func unsetenv(t *testing.T, name string) {
+	previous, ok := os.LookupEnv(name)
+	os.Unsetenv(name)
+
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, previous)
+		}
+	})
+}
+
+func writeConfigurationFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func defaultConfiguration(t *testing.T) Configuration {
+	var c Configuration
+	err := envconfig.Process("", &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Password = "secret"
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	for _, f := range fields(Configuration{}) {
+		unsetenv(t, f.name)
+	}
+
+	tests := []struct {
+		name     string
+		change   func(c *Configuration)
+		problems []string
+	}{
+		{"defaults", func(c *Configuration) {}, nil},
+		{"log level", func(c *Configuration) { c.LogLevel = "loud" }, []string{`LOG_LEVEL "loud" isn't a log level`}},
+		{"storage backend", func(c *Configuration) { c.StorageBackend = "sqlite" }, []string{`STORAGE_BACKEND must be one of postgres, embedded, not "sqlite"`}},
+		{"authenticator", func(c *Configuration) { c.Authenticators = []string{"token", "password"} }, []string{`AUTHENTICATORS must be one of token, apikey, mtls, not "password"`}},
+		{"no authenticators", func(c *Configuration) { c.Authenticators = nil }, []string{"AUTHENTICATORS can't be empty"}},
+		{"password", func(c *Configuration) { c.Password = "" }, []string{"POSTGRES_PASSWORD is required with PostgreSQL"}},
+		{"embedded without password", func(c *Configuration) { c.Password, c.StorageBackend = "", "embedded" }, nil},
+		{"mtls", func(c *Configuration) { c.TlsMode = "mtls" }, []string{"TLS_MODE=mtls needs TLS_CLIENT_CA_PATH"}},
+		{"jwt", func(c *Configuration) { c.AuthMode = "jwt" }, []string{"AUTH_MODE=jwt needs JWT_ISSUER", "AUTH_MODE=jwt needs JWT_AUDIENCE"}},
+		{"nothing to listen on", func(c *Configuration) { c.ListenAddress = "" }, []string{"LISTEN_ADDRESS or UNIX_SOCKET is required"}},
+		{"unix socket only", func(c *Configuration) { c.ListenAddress, c.UnixSocket = "", "/tmp/nearbyflights.sock" }, nil},
+		{"negative durations", func(c *Configuration) { c.DedupeWindow, c.ShutdownDelay = -time.Second, -time.Second }, []string{"DEDUPE_WINDOW can't be negative", "SHUTDOWN_DELAY can't be negative"}},
+		{"sample ratio", func(c *Configuration) { c.TracingSampleRatio = 2 }, []string{"TRACING_SAMPLE_RATIO must be between 0 and 1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := defaultConfiguration(t)
+			test.change(&c)
+
+			err := validate(c)
+			if len(test.problems) == 0 {
+				if err != nil {
+					t.Errorf("expected a valid configuration: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != strings.Join(test.problems, "; ") {
+				t.Errorf("expected %q, got %v", strings.Join(test.problems, "; "), err)
+			}
+		})
+	}
+}
+
+func TestSettingUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		yaml    string
+		setting setting
+		ok      bool
+	}{
+		{"text", "text", true},
+		{"10", "10", true},
+		{"0.5", "0.5", true},
+		{"off", "off", true},
+		{"true", "true", true},
+		{"1m30s", "1m30s", true},
+		{`""`, "", true},
+		{"[replica-1:5432, replica-2:5432]", "replica-1:5432,replica-2:5432", true},
+		{"[]", "", true},
+		{"{host: replica}", "", false},
+		{"[[nested]]", "", false},
+	}
+
+	for _, test := range tests {
+		var s setting
+		err := yaml.Unmarshal([]byte(test.yaml), &s)
+		if test.ok && (err != nil || s != test.setting) {
+			t.Errorf("expected %q to read as %q, got %q %v", test.yaml, test.setting, s, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("expected %q to be refused, got %q", test.yaml, s)
+		}
+	}
+}
+
+func TestReadConfigurationFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		values map[string]string
+		err    string
+	}{
+		{"scalars", "storage_backend: embedded\nlimit_max_streams: 20\ntls_mode: off\n",
+			map[string]string{"STORAGE_BACKEND": "embedded", "LIMIT_MAX_STREAMS": "20", "TLS_MODE": "off"}, ""},
+		{"upper case", "LOG_LEVEL: debug\n", map[string]string{"LOG_LEVEL": "debug"}, ""},
+		{"lists", "postgres_replica_urls: [replica-1:5432, replica-2:5432]\nauthenticators:\n  - token\n  - apikey\n",
+			map[string]string{"POSTGRES_REPLICA_URLS": "replica-1:5432,replica-2:5432", "AUTHENTICATORS": "token,apikey"}, ""},
+		{"empty", "", map[string]string{}, ""},
+		{"unknown key", "log_levle: debug\n", nil, `unknown setting "log_levle"`},
+		{"config file", "config_file: other.yaml\n", nil, `unknown setting "config_file"`},
+		{"duplicated key", "log_level: debug\nlog_level: info\n", nil, "error parsing the configuration file"},
+		{"map value", "log_level: {level: debug}\n", nil, "error parsing the configuration file"},
+		{"not a map", "- log_level\n", nil, "error parsing the configuration file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := readConfigurationFile(writeConfigurationFile(t, test.file))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, test.values) {
+				t.Errorf("expected %v, got %v", test.values, values)
+			}
+		})
+	}
+
+	if _, err := readConfigurationFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestLoadConfiguration(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		check func(t *testing.T, c Configuration)
+		err   string
+	}{
+		{
+			name: "the file replaces the defaults",
+			file: "limit_max_streams: 20\ndedupe_window: 30m\ntracing_sample_ratio: 0.5\npostgres_replica_urls: [replica-1:5432, replica-2:5432]\n",
+			check: func(t *testing.T, c Configuration) {
+				if c.LimitMaxStreams != 20 || c.DedupeWindow != 30*time.Minute || c.TracingSampleRatio != 0.5 {
+					t.Errorf("expected the values of the file, got %v %v %v", c.LimitMaxStreams, c.DedupeWindow, c.TracingSampleRatio)
+				}
+				if !reflect.DeepEqual(c.PostgresReplicaUrls, []string{"replica-1:5432", "replica-2:5432"}) {
+					t.Errorf("expected the replicas of the file, got %v", c.PostgresReplicaUrls)
+				}
+				if c.LogLevel != "info" {
+					t.Errorf("expected the default of a setting missing from the file, got %v", c.LogLevel)
+				}
+			},
+		},
+		{
+			name: "the environment wins over the file",
+			file: "log_level: debug\nlimit_max_streams: 20\n",
+			env:  map[string]string{"LOG_LEVEL": "warn"},
+			check: func(t *testing.T, c Configuration) {
+				if c.LogLevel != "warn" || c.LimitMaxStreams != 20 {
+					t.Errorf("expected the log level of the environment and the limit of the file, got %v %v", c.LogLevel, c.LimitMaxStreams)
+				}
+			},
+		},
+		{
+			name: "an empty variable still wins",
+			file: "unix_socket: /tmp/nearbyflights.sock\n",
+			env:  map[string]string{"UNIX_SOCKET": ""},
+			check: func(t *testing.T, c Configuration) {
+				if c.UnixSocket != "" {
+					t.Errorf("expected the empty socket of the environment, got %v", c.UnixSocket)
+				}
+			},
+		},
+		{
+			name: "off stays off",
+			file: "tls_mode: off\n",
+			check: func(t *testing.T, c Configuration) {
+				if c.TlsMode != "off" {
+					t.Errorf("expected TLS to be off, got %v", c.TlsMode)
+				}
+			},
+		},
+		{name: "invalid value", file: "limit_max_streams: many\n", err: "error reading limit_max_streams from the configuration file"},
+		{name: "invalid duration", file: "dedupe_window: forever\n", err: "error reading dedupe_window from the configuration file"},
+		{name: "validation", file: "storage_backend: sqlite\n", err: `STORAGE_BACKEND must be one of postgres, embedded, not "sqlite"`},
+		{name: "unknown key", file: "storage: embedded\n", err: `unknown setting "storage"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, f := range fields(Configuration{}) {
+				unsetenv(t, f.name)
+			}
+			setenv(t, "POSTGRES_PASSWORD", "secret")
+			setenv(t, "CONFIG_FILE", writeConfigurationFile(t, test.file))
+			for name, value := range test.env {
+				setenv(t, name, value)
+			}
+
+			c, err := loadConfiguration()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, c)
+
+			// the file values don't leak into the environment
+			for _, f := range fields(Configuration{}) {
+				_, set := test.env[f.name]
+				if _, ok := os.LookupEnv(f.name); ok && !set && f.name != "POSTGRES_PASSWORD" && f.name != "CONFIG_FILE" {
+					t.Errorf("expected %s to stay out of the environment", f.name)
+				}
+			}
+		})
+	}
+}
+
+func TestReloadOnHangup(t *testing.T) {
+	for _, f := range fields(Configuration{}) {
+		unsetenv(t, f.name)
+	}
+	setenv(t, "POSTGRES_PASSWORD", "secret")
+
+	path := writeConfigurationFile(t, "log_level: info\n")
+	setenv(t, "CONFIG_FILE", path)
+
+	current, err := loadConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+	log.SetLevel(log.InfoLevel)
+
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	// a SIGHUP sent before reloadOnHangup listens must not end the test binary
+	ignored := make(chan os.Signal, 1)
+	signal.Notify(ignored, syscall.SIGHUP)
+	defer signal.Stop(ignored)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloadOnHangup(ctx, current, limit.New(limitOptions(current)), schedule.New(nil, 1))
+
+	// hangup signals until the message is logged, as reloadOnHangup may not be listening yet
+	logged := func(message string) *log.Entry {
+		for i := 0; i < 100; i++ {
+			syscall.Kill(os.Getpid(), syscall.SIGHUP)
+			time.Sleep(20 * time.Millisecond)
+
+			for _, entry := range hook.AllEntries() {
+				if strings.HasPrefix(entry.Message, message) {
+					hook.Reset()
+					return entry
+				}
+			}
+		}
+
+		t.Fatalf("expected %q to be logged", message)
+		return nil
+	}
+
+	err = ioutil.WriteFile(path, []byte("log_level: warn\nlimit_max_streams: 20\nstorage_backend: embedded\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logged("STORAGE_BACKEND changed, restart the server to apply it")
+	if log.GetLevel() != log.WarnLevel {
+		t.Errorf("expected the log level of the file to apply right away, got %v", log.GetLevel())
+	}
+
+	err = ioutil.WriteFile(path, []byte("log_level: debug\nlimit_max_streams: -1\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := logged("error reloading the configuration, keeping the current one")
+	if !strings.Contains(entry.Message, "LIMIT_MAX_STREAMS can't be negative") {
+		t.Errorf("expected the validation error, got %q", entry.Message)
+	}
+	if log.GetLevel() != log.WarnLevel {
+		t.Errorf("expected the log level to be kept after a failed reload, got %v", log.GetLevel())
+	}
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg"
+)
+
+// APIKey is a row of the api_keys table, the key itself is never stored, only its SHA-256 hash.
+type APIKey struct {
+	tableName struct{} `sql:"api_keys"`
+
+	Id         int       `sql:"id"`
+	KeyHash    string    `sql:"key_hash"`
+	Owner      string    `sql:"owner"`
+	Scopes     []string  `sql:"scopes,array"`
+	Tier       string    `sql:"tier"`
+	CreatedAt  time.Time `sql:"created_at"`
+	LastUsedAt time.Time `sql:"last_used_at"`
+	RevokedAt  time.Time `sql:"revoked_at"`
+}
+
+// MigrateAPIKeys creates the api_keys table, or adds the timestamp columns to a table created
+// before they existed.
+func (c *Client) MigrateAPIKeys() error {
+	_, err := c.primary.database.Exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (id serial PRIMARY KEY, key_hash text UNIQUE NOT NULL, owner text NOT NULL, scopes text[], tier text);
+		ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+		ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at timestamptz;
+		ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS revoked_at timestamptz;`)
+	if err != nil {
+		return fmt.Errorf("error migrating api_keys table: %v", err)
+	}
+
+	return nil
+}
+
+// GetAPIKeys returns the keys that were not revoked.
+func (c *Client) GetAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := c.primary.database.Model(&keys).Where("revoked_at IS NULL").Select()
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ListAPIKeys returns every key, revoked ones included.
+func (c *Client) ListAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := c.primary.database.Model(&keys).Order("id").Select()
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (c *Client) CreateAPIKey(key *APIKey) error {
+	key.CreatedAt = time.Now()
+
+	return c.primary.database.Insert(key)
+}
+
+// RotateAPIKey revokes the key and creates a new one with the given hash and the same owner,
+// scopes and tier.
+func (c *Client) RotateAPIKey(id int, keyHash string) (APIKey, error) {
+	var rotated APIKey
+
+	err := c.primary.database.RunInTransaction(func(tx *pg.Tx) error {
+		old := APIKey{Id: id}
+		err := tx.Model(&old).WherePK().Where("revoked_at IS NULL").For("UPDATE").Select()
+		if err == pg.ErrNoRows {
+			return fmt.Errorf("no active API key with id %v", id)
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		_, err = tx.Model(&old).Set("revoked_at = ?", now).WherePK().Update()
+		if err != nil {
+			return err
+		}
+
+		rotated = APIKey{KeyHash: keyHash, Owner: old.Owner, Scopes: old.Scopes, Tier: old.Tier, CreatedAt: now}
+		return tx.Insert(&rotated)
+	})
+
+	return rotated, err
+}
+
+func (c *Client) RevokeAPIKey(id int) error {
+	result, err := c.primary.database.Model(&APIKey{Id: id}).Set("revoked_at = now()").WherePK().Where("revoked_at IS NULL").Update()
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() < 1 {
+		return fmt.Errorf("no active API key with id %v", id)
+	}
+
+	return nil
+}
+
+// TouchAPIKeys records that the keys with the given hashes were just used.
+func (c *Client) TouchAPIKeys(keyHashes []string) error {
+	_, err := c.primary.database.Model((*APIKey)(nil)).Set("last_used_at = now()").Where("key_hash IN (?)", pg.In(keyHashes)).Update()
+	return err
+}
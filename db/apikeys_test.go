@@ -0,0 +1,90 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient connects to the same local database as the gRPC tests and skips the test without one.
+func newTestClient(t *testing.T) *Client {
+	c := NewClient(ClientOptions{Address: "localhost:5432", User: "admin", Password: "secret", Database: "flights"})
+	t.Cleanup(c.Close)
+
+	if err := c.primary.ping(); err != nil {
+		t.Skipf("PostgreSQL isn't available: %v", err)
+	}
+
+	return c
+}
+
+func TestAPIKeys(t *testing.T) {
+	c := newTestClient(t)
+
+	if err := c.MigrateAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := fmt.Sprintf("test-%v-", time.Now().UnixNano())
+	defer c.primary.database.Exec("DELETE FROM api_keys WHERE key_hash LIKE ?", prefix+"%")
+
+	key := APIKey{KeyHash: prefix + "first", Owner: "partner", Scopes: []string{"flights:read"}, Tier: "partner"}
+	if err := c.CreateAPIKey(&key); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := c.RotateAPIKey(key.Id, prefix+"second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Owner != "partner" || rotated.Tier != "partner" || len(rotated.Scopes) != 1 {
+		t.Errorf("expected the rotated key to keep the owner, scopes and tier, got %+v", rotated)
+	}
+
+	if _, err := c.RotateAPIKey(key.Id, prefix+"third"); err == nil {
+		t.Error("expected an error rotating a revoked key")
+	}
+
+	if err := c.TouchAPIKeys([]string{rotated.KeyHash}); err != nil {
+		t.Fatal(err)
+	}
+
+	active := testKeys(t, c.GetAPIKeys, prefix)
+	if len(active) != 1 || active[0].KeyHash != rotated.KeyHash || active[0].LastUsedAt.IsZero() {
+		t.Errorf("expected only the rotated key, used, to be active, got %+v", active)
+	}
+
+	if err := c.RevokeAPIKey(rotated.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.RevokeAPIKey(rotated.Id); err == nil {
+		t.Error("expected an error revoking a revoked key")
+	}
+
+	if active := testKeys(t, c.GetAPIKeys, prefix); len(active) != 0 {
+		t.Errorf("expected no active key, got %+v", active)
+	}
+
+	if all := testKeys(t, c.ListAPIKeys, prefix); len(all) != 2 {
+		t.Errorf("expected both keys to be listed, got %+v", all)
+	}
+}
+
+// testKeys returns the keys created by the test, the table may hold others.
+func testKeys(t *testing.T, get func() ([]APIKey, error), prefix string) []APIKey {
+	keys, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var own []APIKey
+	for _, k := range keys {
+		if strings.HasPrefix(k.KeyHash, prefix) {
+			own = append(own, k)
+		}
+	}
+
+	return own
+}
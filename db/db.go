@@ -1,13 +1,22 @@
 package db
 
 import (
+	"context"
 	"fmt"
-	"github.com/go-pg/pg"
+	"time"
+
 	"github.com/go-pg/pg/types"
 	"github.com/nearbyflights/nearbyflights/bbox"
-	log "github.com/sirupsen/logrus"
+	"github.com/nearbyflights/nearbyflights/logging"
+	"github.com/nearbyflights/nearbyflights/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/nearbyflights/nearbyflights/db")
+
 type Flight struct {
 	Id        int     `sql:"id"`
 	Geometry  types.Q `sql:"geom"`
@@ -16,54 +25,128 @@ type Flight struct {
 	Country   string  `sql:"country"`
 	CallSign  string  `sql:"call_sign"`
 	Icao24    string  `sql:"icao"`
-	Velocity  float64 `sql:velocity`
+	Velocity  float64 `sql:"velocity"`
 }
 
 type Client struct {
-	database *pg.DB
+	primary  *node
+	replicas []*node
+	next     uint32
+	done     chan struct{}
+
+	updatedAtColumn string
 }
 
 type ClientOptions struct {
-	Address  string
-	User     string
-	Password string
-	Database string
+	Address             string
+	Replicas            []string
+	User                string
+	Password            string
+	Database            string
+	HealthCheckInterval time.Duration
+	// UpdatedAtColumn is the timestamp column of the flights table telling when each flight was last written.
+	UpdatedAtColumn string
 }
 
-func NewClient(options ClientOptions) Client {
-	db := pg.Connect(&pg.Options{
-		Addr:     options.Address,
-		User:     options.User,
-		Password: options.Password,
-		Database: options.Database,
-	})
+func NewClient(options ClientOptions) *Client {
+	c := &Client{
+		primary:         newNode(options, options.Address),
+		done:            make(chan struct{}),
+		updatedAtColumn: options.UpdatedAtColumn,
+	}
+
+	for _, address := range options.Replicas {
+		c.replicas = append(c.replicas, newNode(options, address))
+	}
+
+	c.checkHealth()
 
-	return Client{db}
+	if options.HealthCheckInterval > 0 {
+		go c.healthCheck(options.HealthCheckInterval)
+	}
+
+	return c
 }
 
 func (c *Client) AddTestFlight(flight Flight) error {
 	flight.CallSign = "test-flight"
-	_, err := c.database.Model(&flight).Insert()
+	_, err := c.primary.database.Model(&flight).Insert()
 	return err
 }
 
 func (c *Client) RemoveTestFlight() error {
-	_, err := c.database.Model((*Flight)(nil)).Where("call_sign = 'test-flight'").Delete()
+	_, err := c.primary.database.Model((*Flight)(nil)).Where("call_sign = 'test-flight'").Delete()
 	return err
 }
 
-func (c *Client) GetFlights(box bbox.BoundingBox) ([]Flight, error) {
+// GetFlights is served by a healthy replica when there is one, otherwise by the primary. A query
+// failing on a replica is tried once more on the primary.
+func (c *Client) GetFlights(ctx context.Context, box bbox.BoundingBox) ([]Flight, error) {
+	where := fmt.Sprintf("geom && ST_MakeEnvelope(%v, %v, %v, %v, 4326)", box.MinLongitude, box.MinLatitude, box.MaxLongitude, box.MaxLatitude)
+
+	ctx, span := tracer.Start(ctx, "db.GetFlights", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemPostgres,
+		semconv.DBStatementKey.String("SELECT ... FROM flights WHERE "+where),
+	))
+	defer span.End()
+
 	var flights []Flight
-	err := c.database.Model(&flights).Where(fmt.Sprintf("geom && ST_MakeEnvelope(%v, %v, %v, %v, 4326)", box.MinLongitude, box.MinLatitude, box.MaxLongitude, box.MaxLatitude)).Select()
+	err := c.onReader(ctx, func(reader *node) error {
+		span.SetAttributes(semconv.NetPeerNameKey.String(reader.address))
+		start := time.Now()
+
+		flights = nil
+		err := reader.database.WithContext(ctx).Model(&flights).Where(where).Select()
+		observe("get_flights", reader, start, err)
+
+		// a stream that went away cancels its query, that says nothing about the node
+		if err != nil && ctx.Err() == nil {
+			reader.markUnhealthy(err)
+		}
+
+		if err == nil && logging.Sample(ctx, "db") {
+			logging.FromContext(ctx).Debugf("found %v flight(s) on %s", len(flights), reader.address)
+		}
+
+		return err
+	})
 	if err != nil {
+		tracing.Fail(span, err)
 		return nil, err
 	}
 
-	log.Infof("found %v flight(s)", len(flights))
+	span.SetAttributes(label.Int("flights", len(flights)))
+
+	return flights, nil
+}
+
+// GetAllFlights returns every current flight, it is used to load in-memory snapshots of the table.
+func (c *Client) GetAllFlights() ([]Flight, error) {
+	var flights []Flight
+	err := c.onReader(context.Background(), func(reader *node) error {
+		start := time.Now()
+
+		flights = nil
+		err := reader.database.Model(&flights).Select()
+		observe("get_all_flights", reader, start, err)
+		if err != nil {
+			reader.markUnhealthy(err)
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	return flights, nil
 }
 
 func (c *Client) Close() {
-	c.database.Close()
+	close(c.done)
+
+	c.primary.database.Close()
+	for _, r := range c.replicas {
+		r.database.Close()
+	}
 }
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-pg/pg"
+)
+
+// Freshness is implemented by stores that know when their flights were last written.
+type Freshness interface {
+	LastUpdate(ctx context.Context) (time.Time, error)
+}
+
+// Ping checks that the primary accepts queries.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.primary.database.WithContext(ctx).Exec("SELECT 1")
+	return err
+}
+
+// PostGISVersion returns the version of the PostGIS extension, failing when it isn't installed.
+func (c *Client) PostGISVersion(ctx context.Context) (string, error) {
+	var version string
+	_, err := c.primary.database.WithContext(ctx).QueryOne(pg.Scan(&version), "SELECT PostGIS_Version()")
+	return version, err
+}
+
+// LastUpdate returns the newest value of the update timestamp column of the flights table.
+func (c *Client) LastUpdate(ctx context.Context) (time.Time, error) {
+	if c.updatedAtColumn == "" {
+		return time.Time{}, errors.New("no update timestamp column configured")
+	}
+
+	var updated pg.NullTime
+	_, err := c.primary.database.WithContext(ctx).QueryOne(pg.Scan(&updated), "SELECT max(?) FROM flights", pg.F(c.updatedAtColumn))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return updated.Time, nil
+}
+
+// HasColumn tells whether the table has the column.
+func (c *Client) HasColumn(ctx context.Context, table string, column string) (bool, error) {
+	var exists bool
+	_, err := c.primary.database.WithContext(ctx).QueryOne(pg.Scan(&exists),
+		"SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?)", table, column)
+	return exists, err
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nearbyflights_db_query_duration_seconds",
+		Help:    "Duration of flight queries to PostgreSQL.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"query", "node"})
+
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nearbyflights_db_query_errors_total",
+		Help: "Flight queries to PostgreSQL that failed.",
+	}, []string{"query", "node"})
+)
+
+// observe records the duration and the outcome of a query run on the node.
+func observe(query string, n *node, start time.Time, err error) {
+	queryDuration.WithLabelValues(query, n.address).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		queryErrors.WithLabelValues(query, n.address).Inc()
+	}
+}
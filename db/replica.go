@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg"
+	log "github.com/sirupsen/logrus"
+)
+
+type node struct {
+	address  string
+	database *pg.DB
+	healthy  int32
+}
+
+func newNode(options ClientOptions, address string) *node {
+	return &node{
+		address: address,
+		database: pg.Connect(&pg.Options{
+			Addr:     address,
+			User:     options.User,
+			Password: options.Password,
+			Database: options.Database,
+		}),
+	}
+}
+
+func (n *node) isHealthy() bool {
+	return atomic.LoadInt32(&n.healthy) == 1
+}
+
+func (n *node) setHealthy(healthy bool) {
+	var value int32
+	if healthy {
+		value = 1
+	}
+
+	if atomic.SwapInt32(&n.healthy, value) != value {
+		log.Infof("database node %s healthy: %v", n.address, healthy)
+	}
+}
+
+func (n *node) markUnhealthy(err error) {
+	log.Errorf("query on database node %s failed: %v", n.address, err)
+	n.setHealthy(false)
+}
+
+func (n *node) ping() error {
+	_, err := n.database.Exec("SELECT 1")
+	return err
+}
+
+// reader picks the next healthy replica in round-robin order and falls back to the primary
+// when no replica is available.
+func (c *Client) reader() *node {
+	count := uint32(len(c.replicas))
+	if count == 0 {
+		return c.primary
+	}
+
+	start := atomic.AddUint32(&c.next, 1)
+	for i := uint32(0); i < count; i++ {
+		r := c.replicas[(start+i)%count]
+		if r.isHealthy() {
+			return r
+		}
+	}
+
+	return c.primary
+}
+
+// onReader runs the query on the next reader and, when it fails on a replica, once more on the
+// primary. The primary isn't tried again, nor are queries whose context is done.
+func (c *Client) onReader(ctx context.Context, query func(reader *node) error) error {
+	reader := c.reader()
+
+	err := query(reader)
+	if err == nil || reader == c.primary || ctx.Err() != nil {
+		return err
+	}
+
+	log.Warnf("retrying the query of database node %s on the primary", reader.address)
+
+	return query(c.primary)
+}
+
+func (c *Client) checkHealth() {
+	for _, n := range append([]*node{c.primary}, c.replicas...) {
+		err := n.ping()
+		if err != nil {
+			log.Warnf("health check of database node %s failed: %v", n.address, err)
+		}
+
+		n.setHealthy(err == nil)
+	}
+}
+
+func (c *Client) healthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.checkHealth()
+		case <-c.done:
+			return
+		}
+	}
+}
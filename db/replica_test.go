@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReader_HealthyReplica(t *testing.T) {
+	primary := &node{address: "primary"}
+	replica := &node{address: "replica", healthy: 1}
+	c := &Client{primary: primary, replicas: []*node{{address: "down"}, replica}}
+
+	for i := 0; i < 4; i++ {
+		if r := c.reader(); r != replica {
+			t.Errorf("reader should be the healthy replica, got %s", r.address)
+		}
+	}
+}
+
+func TestReader_FallbackToPrimary(t *testing.T) {
+	primary := &node{address: "primary"}
+	c := &Client{primary: primary, replicas: []*node{{address: "down"}}}
+
+	if r := c.reader(); r != primary {
+		t.Errorf("reader should fall back to the primary, got %s", r.address)
+	}
+}
+
+func TestReader_NoReplicas(t *testing.T) {
+	primary := &node{address: "primary"}
+	c := &Client{primary: primary}
+
+	if r := c.reader(); r != primary {
+		t.Errorf("reader should be the primary, got %s", r.address)
+	}
+}
+
+func TestOnReader_RetryOnPrimary(t *testing.T) {
+	primary := &node{address: "primary", healthy: 1}
+	c := &Client{primary: primary, replicas: []*node{{address: "replica", healthy: 1}}}
+
+	var tried []string
+	err := c.onReader(context.Background(), func(reader *node) error {
+		tried = append(tried, reader.address)
+		if reader != primary {
+			return errors.New("replica failed")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("the query should succeed on the primary: %v", err)
+	}
+
+	if len(tried) != 2 || tried[0] != "replica" || tried[1] != "primary" {
+		t.Errorf("the query should run on the replica and then the primary, ran on %v", tried)
+	}
+}
+
+func TestOnReader_NoRetry(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		replicas []*node
+		tries    int
+	}{
+		{name: "primary fails", ctx: context.Background(), tries: 1},
+		{name: "context done", ctx: canceled, replicas: []*node{{address: "replica", healthy: 1}}, tries: 1},
+		{name: "replica and primary fail", ctx: context.Background(), replicas: []*node{{address: "replica", healthy: 1}}, tries: 2},
+	}
+
+	for _, test := range tests {
+		c := &Client{primary: &node{address: "primary"}, replicas: test.replicas}
+
+		tries := 0
+		err := c.onReader(test.ctx, func(*node) error {
+			tries++
+			return errors.New("query failed")
+		})
+
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+
+		if tries != test.tries {
+			t.Errorf("%s: expected %d tries, got %d", test.name, test.tries, tries)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package db
+
+import (
+	"context"
+
+	"github.com/nearbyflights/nearbyflights/bbox"
+)
+
+// Store is a source of current flight positions, either PostgreSQL (Client) or an embedded backend.
+type Store interface {
+	GetFlights(ctx context.Context, box bbox.BoundingBox) ([]Flight, error)
+	GetAllFlights() ([]Flight, error)
+	Close()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/authentication"
+	"github.com/nearbyflights/nearbyflights/embedded"
+	grpcService "github.com/nearbyflights/nearbyflights/grpc"
+	"github.com/nearbyflights/nearbyflights/healthcheck"
+	service "github.com/nearbyflights/nearbyflights/proto"
+	"github.com/nearbyflights/nearbyflights/schedule"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve starts the gRPC server on an in-memory listener and returns a connection to it.
+func serve(t *testing.T, grpcServer *grpc.Server) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestDrain(t *testing.T) {
+	store, err := embedded.Open(embedded.Options{Path: filepath.Join(t.TempDir(), "flights.db"), CellSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scheduler := schedule.New(store, 1)
+	go scheduler.Run(ctx)
+
+	wg := &sync.WaitGroup{}
+	server := &grpcService.Server{Scheduler: scheduler, Context: ctx, Wg: wg}
+	checker := healthcheck.New("nearbyflights", health.NewServer(), time.Minute, time.Second)
+
+	grpcServer := grpc.NewServer()
+	service.RegisterNearbyFlightsServer(grpcServer, server)
+	grpc_health_v1.RegisterHealthServer(grpcServer, checker.Server())
+	conn := serve(t, grpcServer)
+
+	watch, err := grpc_health_v1.NewHealthClient(conn).Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "nearbyflights"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := service.NewNearbyFlightsClient(conn)
+	options := &service.Options{Latitude: 7, Longitude: 40, Radius: 1000}
+
+	stream, err := client.ReceiveUpdates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(options); err != nil {
+		t.Fatal(err)
+	}
+
+	flights, err := client.Receive(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flights.Send(options); err != nil {
+		t.Fatal(err)
+	}
+
+	// the streams have to be open on the server before it drains
+	admin := &grpcService.Admin{Server: server, Scope: "admin"}
+	adminCtx := authentication.NewContext(context.Background(), authentication.Principal{Scopes: []string{"admin"}})
+	for i := 0; ; i++ {
+		response, err := admin.ListStreams(adminCtx, &service.ListStreamsRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Streams) == 2 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the streams never opened")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := drain(grpcServer, checker, cancel, wg, 0, 5*time.Second); code != exitDrained {
+		t.Errorf("expected a clean drain, got exit code %v", code)
+	}
+
+	update, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !update.GetStatus().GetDraining() {
+		t.Errorf("expected the draining notice, got %v", update)
+	}
+
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the stream to close with Unavailable, got %v", err)
+	}
+
+	// Receive streams only carry flights, the notice is in their trailers
+	if _, err := flights.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the Receive stream to close with Unavailable, got %v", err)
+	}
+	if notice := flights.Trailer().Get("nearbyflights-draining"); len(notice) != 1 || notice[0] != "server draining, reconnect" {
+		t.Errorf("expected the draining notice in the Receive trailers, got %v", flights.Trailer())
+	}
+
+	for {
+		response, err := watch.Recv()
+		if err != nil {
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("expected the health watch to end with Unavailable, got %v", err)
+			}
+			break
+		}
+		if response.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("expected the health watch to report NOT_SERVING, got %v", response.Status)
+		}
+	}
+}
+
+func TestDrain_Timeout(t *testing.T) {
+	checker := healthcheck.New("nearbyflights", health.NewServer(), time.Minute, time.Second)
+
+	// a health server that isn't the checker's keeps its watchers and the graceful stop waiting
+	grpcServer := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(grpcServer, health.NewServer())
+	conn := serve(t, grpcServer)
+
+	watch, err := grpc_health_v1.NewHealthClient(conn).Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, cancel := context.WithCancel(context.Background())
+	if code := drain(grpcServer, checker, cancel, &sync.WaitGroup{}, 0, 100*time.Millisecond); code != exitDrainTimeout {
+		t.Errorf("expected the drain to time out, got exit code %v", code)
+	}
+
+	if _, err := watch.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the watch to be closed by the stop, got %v", err)
+	}
+}
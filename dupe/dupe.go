@@ -1,15 +1,24 @@
 package dupe
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type flightDupe struct {
 	Icao24   string
 	LastSeen time.Time
 }
 
-var flightsByUser = make(map[string][]flightDupe)
+var (
+	mutex         sync.Mutex
+	flightsByUser = make(map[string][]flightDupe)
+)
 
 func Exists(token string, icao string, interval time.Duration) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
 	flights := flightsByUser[token]
 
 	exists := false
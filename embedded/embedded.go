@@ -0,0 +1,315 @@
+package embedded
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/bbox"
+	"github.com/nearbyflights/nearbyflights/db"
+	"github.com/nearbyflights/nearbyflights/grid"
+	log "github.com/sirupsen/logrus"
+)
+
+// the log is rewritten when it holds this many times more records than there are live flights
+const compactionFactor = 4
+
+type record struct {
+	Op        string  `json:"op"`
+	Icao24    string  `json:"icao"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	Country   string  `json:"country,omitempty"`
+	CallSign  string  `json:"call_sign,omitempty"`
+	Velocity  float64 `json:"velocity,omitempty"`
+}
+
+func putRecord(f db.Flight) record {
+	return record{
+		Op:        "put",
+		Icao24:    f.Icao24,
+		Latitude:  f.Latitude,
+		Longitude: f.Longitude,
+		Country:   f.Country,
+		CallSign:  f.CallSign,
+		Velocity:  f.Velocity,
+	}
+}
+
+func (r record) flight() db.Flight {
+	return db.Flight{
+		Icao24:    r.Icao24,
+		Latitude:  r.Latitude,
+		Longitude: r.Longitude,
+		Country:   r.Country,
+		CallSign:  r.CallSign,
+		Velocity:  r.Velocity,
+	}
+}
+
+type Options struct {
+	Path     string
+	CellSize float64
+}
+
+// Store is a single-node flight store kept in memory and persisted to an append-only file.
+type Store struct {
+	mutex   sync.RWMutex
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	records int
+	index   *grid.Index
+	updated time.Time
+}
+
+func Open(options Options) (*Store, error) {
+	s := &Store{path: options.Path, index: grid.New(options.CellSize)}
+
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// flights loaded from the file are as old as its last write
+	if info, err := os.Stat(s.path); err == nil {
+		s.updated = info.ModTime()
+	}
+
+	// start from a compacted file so the log only grows with writes made by this process
+	err = s.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("opened embedded store %s with %v flight(s)", s.path, s.index.Len())
+
+	return s, nil
+}
+
+func (s *Store) GetFlights(_ context.Context, box bbox.BoundingBox) ([]db.Flight, error) {
+	s.mutex.RLock()
+	flights := s.index.Search(box)
+	s.mutex.RUnlock()
+
+	log.Debugf("found %v flight(s)", len(flights))
+
+	return flights, nil
+}
+
+func (s *Store) GetAllFlights() ([]db.Flight, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.index.All(), nil
+}
+
+// Put inserts or updates the current position of the given flights.
+func (s *Store) Put(flights ...db.Flight) error {
+	records := make([]record, 0, len(flights))
+	for _, f := range flights {
+		records = append(records, putRecord(f))
+	}
+
+	return s.apply(records)
+}
+
+func (s *Store) Delete(icao ...string) error {
+	records := make([]record, 0, len(icao))
+	for _, i := range icao {
+		records = append(records, record{Op: "delete", Icao24: i})
+	}
+
+	return s.apply(records)
+}
+
+// apply writes the records to the log and the index in order.
+func (s *Store) apply(records []record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, r := range records {
+		switch r.Op {
+		case "put":
+		case "delete":
+			if _, ok := s.index.Get(r.Icao24); !ok {
+				continue
+			}
+		default:
+			log.Warnf("skipping record with unknown operation %q for flight %s", r.Op, r.Icao24)
+			continue
+		}
+
+		err := s.append(r)
+		if err != nil {
+			return err
+		}
+
+		if r.Op == "put" {
+			s.index.Insert(r.flight())
+		} else {
+			s.index.Remove(r.Icao24)
+		}
+	}
+
+	s.updated = time.Now()
+
+	return s.flush()
+}
+
+// LastUpdate returns when flights were last put or deleted.
+func (s *Store) LastUpdate(context.Context) (time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.updated, nil
+}
+
+func (s *Store) AddTestFlight(flight db.Flight) error {
+	flight.CallSign = "test-flight"
+	return s.Put(flight)
+}
+
+func (s *Store) RemoveTestFlight() error {
+	var icao []string
+
+	s.mutex.RLock()
+	for _, f := range s.index.All() {
+		if f.CallSign == "test-flight" {
+			icao = append(icao, f.Icao24)
+		}
+	}
+	s.mutex.RUnlock()
+
+	return s.Delete(icao...)
+}
+
+func (s *Store) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.writer.Flush()
+	if err != nil {
+		log.Errorf("error flushing embedded store %s: %v", s.path, err)
+	}
+
+	s.file.Close()
+}
+
+func (s *Store) load() error {
+	records, err := readRecords(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening embedded store: %v", err)
+	}
+
+	for _, r := range records {
+		switch r.Op {
+		case "put":
+			s.index.Insert(r.flight())
+		case "delete":
+			s.index.Remove(r.Icao24)
+		}
+	}
+
+	return nil
+}
+
+// readRecords reads a file of records, one JSON object per line.
+func readRecords(path string) ([]record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var records []record
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r record
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			// a crash in the middle of a write leaves a truncated last line behind
+			log.Warnf("skipping corrupted record in %s: %v", path, err)
+			continue
+		}
+
+		records = append(records, r)
+	}
+
+	return records, scanner.Err()
+}
+
+func (s *Store) append(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.writer.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("error writing to embedded store: %v", err)
+	}
+
+	s.records++
+
+	return nil
+}
+
+func (s *Store) flush() error {
+	err := s.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("error writing to embedded store: %v", err)
+	}
+
+	if s.records > compactionFactor*s.index.Len() && s.records > 1000 {
+		return s.compact()
+	}
+
+	return nil
+}
+
+// compact rewrites the file with one record per live flight and swaps it in place of the current one.
+func (s *Store) compact() error {
+	temporary := s.path + ".tmp"
+
+	file, err := os.Create(temporary)
+	if err != nil {
+		return fmt.Errorf("error compacting embedded store: %v", err)
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	s.records = 0
+
+	for _, f := range s.index.All() {
+		err := s.append(putRecord(f))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("error compacting embedded store: %v", err)
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return fmt.Errorf("error compacting embedded store: %v", err)
+	}
+
+	return os.Rename(temporary, s.path)
+}
@@ -0,0 +1,67 @@
+package embedded
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/nearbyflights/nearbyflights/bbox"
+	"github.com/nearbyflights/nearbyflights/db"
+)
+
+// 5km radius in CGH airport
+var box = bbox.NewBoundingBox(-23.627238, -46.655919, 5000)
+
+func TestGetFlights_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flights.db")
+
+	store, err := Open(Options{Path: path, CellSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(db.Flight{Icao24: "kept", Latitude: -23.63, Longitude: -46.66}, db.Flight{Icao24: "deleted", Latitude: -23.62, Longitude: -46.65})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Delete("deleted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Close()
+
+	store, err = Open(Options{Path: path, CellSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	flights, err := store.GetFlights(context.Background(), box)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(flights) != 1 || flights[0].Icao24 != "kept" {
+		t.Errorf("unexpected flights after reopening the store: %v", flights)
+	}
+}
+
+func TestRemoveTestFlight(t *testing.T) {
+	store, err := Open(Options{Path: filepath.Join(t.TempDir(), "flights.db"), CellSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	_ = store.AddTestFlight(db.Flight{Icao24: "123456", Latitude: -23.63, Longitude: -46.66})
+	_ = store.RemoveTestFlight()
+
+	flights, _ := store.GetFlights(context.Background(), box)
+	if len(flights) != 0 {
+		t.Errorf("test flight should have been removed: %v", flights)
+	}
+}
@@ -0,0 +1,64 @@
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Import applies the records of the file, written in the format of the store log, and removes it. It
+// returns how many records were read, zero when there is no file. Feeders write the file elsewhere
+// and rename it to path, so it is never read half written.
+func (s *Store) Import(path string) (int, error) {
+	importing := path + ".importing"
+
+	// a file left behind by an import that failed is applied again before taking a new one
+	if _, err := os.Stat(importing); os.IsNotExist(err) {
+		err = os.Rename(path, importing)
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error taking the import file: %v", err)
+		}
+	}
+
+	records, err := readRecords(importing)
+	if err != nil {
+		return 0, fmt.Errorf("error reading the import file: %v", err)
+	}
+
+	if len(records) > 0 {
+		err = s.apply(records)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(records), os.Remove(importing)
+}
+
+// RunImport imports the file at path every period until the context is done.
+func (s *Store) RunImport(ctx context.Context, path string, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := s.Import(path)
+			if err != nil {
+				log.Errorf("error importing flights from %s: %v", path, err)
+				continue
+			}
+			if n > 0 {
+				log.Debugf("imported %v record(s) from %s", n, path)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
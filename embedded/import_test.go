@@ -0,0 +1,76 @@
+package embedded
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeImport(t *testing.T, path string, content string) {
+	err := ioutil.WriteFile(path+".tmp", []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flights.import")
+
+	store, err := Open(Options{Path: filepath.Join(dir, "flights.db"), CellSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the store is reopened below
+	defer func() { store.Close() }()
+
+	n, err := store.Import(path)
+	if n != 0 || err != nil {
+		t.Fatalf("expected nothing to import without a file, got %v %v", n, err)
+	}
+
+	writeImport(t, path, `{"op": "put", "icao": "kept", "latitude": -23.63, "longitude": -46.66}
+{"op": "put", "icao": "deleted", "latitude": -23.62, "longitude": -46.65}
+{"op": "delete", "icao": "deleted"}
+{"op": "put", "icao": "trunc
+`)
+
+	n, err = store.Import(path)
+	if n != 3 || err != nil {
+		t.Fatalf("expected 3 records imported, got %v %v", n, err)
+	}
+
+	flights, _ := store.GetFlights(context.Background(), box)
+	if len(flights) != 1 || flights[0].Icao24 != "kept" {
+		t.Errorf("unexpected flights after the import: %v", flights)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the import file to be removed: %v", err)
+	}
+
+	updated, _ := store.LastUpdate(context.Background())
+	if updated.IsZero() {
+		t.Error("expected the import to count as an update")
+	}
+
+	// the imported flights are in the log like any other write
+	store.Close()
+	store, err = Open(Options{Path: filepath.Join(dir, "flights.db"), CellSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flights, _ = store.GetFlights(context.Background(), box)
+	if len(flights) != 1 || flights[0].Icao24 != "kept" {
+		t.Errorf("unexpected flights after reopening the store: %v", flights)
+	}
+}
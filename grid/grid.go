@@ -0,0 +1,127 @@
+package grid
+
+import (
+	"math"
+
+	"github.com/nearbyflights/nearbyflights/bbox"
+	"github.com/nearbyflights/nearbyflights/db"
+)
+
+type cell struct {
+	x int
+	y int
+}
+
+// Index is a fixed-size grid of flights keyed by ICAO 24-bit address.
+// Searches may run concurrently with each other but not with Insert or Remove.
+type Index struct {
+	cellSize float64
+	cells    map[cell]map[string]db.Flight
+	flights  map[string]cell
+}
+
+func New(cellSize float64) *Index {
+	if cellSize <= 0 {
+		cellSize = 1
+	}
+
+	return &Index{
+		cellSize: cellSize,
+		cells:    make(map[cell]map[string]db.Flight),
+		flights:  make(map[string]cell),
+	}
+}
+
+func (i *Index) Insert(flight db.Flight) {
+	i.Remove(flight.Icao24)
+
+	c := i.cellOf(flight.Latitude, flight.Longitude)
+	if i.cells[c] == nil {
+		i.cells[c] = make(map[string]db.Flight)
+	}
+
+	i.cells[c][flight.Icao24] = flight
+	i.flights[flight.Icao24] = c
+}
+
+func (i *Index) Remove(icao string) {
+	c, ok := i.flights[icao]
+	if !ok {
+		return
+	}
+
+	delete(i.cells[c], icao)
+	if len(i.cells[c]) == 0 {
+		delete(i.cells, c)
+	}
+
+	delete(i.flights, icao)
+}
+
+func (i *Index) Get(icao string) (db.Flight, bool) {
+	c, ok := i.flights[icao]
+	if !ok {
+		return db.Flight{}, false
+	}
+
+	return i.cells[c][icao], true
+}
+
+func (i *Index) Search(box bbox.BoundingBox) []db.Flight {
+	min := i.cellOf(box.MinLatitude, box.MinLongitude)
+	max := i.cellOf(box.MaxLatitude, box.MaxLongitude)
+
+	flights := make([]db.Flight, 0)
+
+	// a large box covers more cells than there are occupied cells, so walk the occupied ones instead
+	if (max.x-min.x+1)*(max.y-min.y+1) > len(i.cells) {
+		for c, cellFlights := range i.cells {
+			if c.x >= min.x && c.x <= max.x && c.y >= min.y && c.y <= max.y {
+				flights = appendInside(flights, cellFlights, box)
+			}
+		}
+
+		return flights
+	}
+
+	for x := min.x; x <= max.x; x++ {
+		for y := min.y; y <= max.y; y++ {
+			flights = appendInside(flights, i.cells[cell{x, y}], box)
+		}
+	}
+
+	return flights
+}
+
+func (i *Index) All() []db.Flight {
+	flights := make([]db.Flight, 0, len(i.flights))
+	for _, cellFlights := range i.cells {
+		for _, f := range cellFlights {
+			flights = append(flights, f)
+		}
+	}
+
+	return flights
+}
+
+func (i *Index) Len() int {
+	return len(i.flights)
+}
+
+func (i *Index) cellOf(latitude float64, longitude float64) cell {
+	return cell{
+		x: int(math.Floor(longitude / i.cellSize)),
+		y: int(math.Floor(latitude / i.cellSize)),
+	}
+}
+
+func appendInside(flights []db.Flight, cellFlights map[string]db.Flight, box bbox.BoundingBox) []db.Flight {
+	for _, f := range cellFlights {
+		if f.Latitude >= box.MinLatitude && f.Latitude <= box.MaxLatitude &&
+			f.Longitude >= box.MinLongitude && f.Longitude <= box.MaxLongitude {
+			flights = append(flights, f)
+		}
+	}
+
+	return flights
+}
@@ -0,0 +1,58 @@
+package grid
+
+import (
+	"testing"
+
+	"github.com/nearbyflights/nearbyflights/bbox"
+	"github.com/nearbyflights/nearbyflights/db"
+)
+
+// 5km radius in CGH airport
+var box = bbox.NewBoundingBox(-23.627238, -46.655919, 5000)
+
+func TestSearch(t *testing.T) {
+	index := New(1)
+	index.Insert(db.Flight{Icao24: "inside", Latitude: -23.63, Longitude: -46.66})
+	index.Insert(db.Flight{Icao24: "outside", Latitude: -22.9, Longitude: -43.17})
+
+	flights := index.Search(box)
+
+	if len(flights) != 1 || flights[0].Icao24 != "inside" {
+		t.Errorf("unexpected flights: %v", flights)
+	}
+}
+
+func TestSearch_LargeBox(t *testing.T) {
+	index := New(0.1)
+	index.Insert(db.Flight{Icao24: "inside", Latitude: -23.63, Longitude: -46.66})
+
+	flights := index.Search(bbox.BoundingBox{MinLatitude: -90, MinLongitude: -180, MaxLatitude: 90, MaxLongitude: 180})
+
+	if len(flights) != 1 {
+		t.Errorf("unexpected flights: %v", flights)
+	}
+}
+
+func TestInsert_MovesFlight(t *testing.T) {
+	index := New(1)
+	index.Insert(db.Flight{Icao24: "moving", Latitude: -22.9, Longitude: -43.17})
+	index.Insert(db.Flight{Icao24: "moving", Latitude: -23.63, Longitude: -46.66})
+
+	if index.Len() != 1 {
+		t.Errorf("index should have 1 flight, got %v", index.Len())
+	}
+
+	if flights := index.Search(box); len(flights) != 1 {
+		t.Errorf("moved flight should be found in its new cell: %v", flights)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	index := New(1)
+	index.Insert(db.Flight{Icao24: "removed", Latitude: -23.63, Longitude: -46.66})
+	index.Remove("removed")
+
+	if flights := index.Search(box); len(flights) != 0 {
+		t.Errorf("removed flight should not be found: %v", flights)
+	}
+}
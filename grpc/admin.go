@@ -0,0 +1,111 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nearbyflights/nearbyflights/audit"
+	"github.com/nearbyflights/nearbyflights/authentication"
+	service "github.com/nearbyflights/nearbyflights/proto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Admin lets operators inspect and close the streams of Server and change the log level. Every
+// call needs a principal with Scope.
+type Admin struct {
+	Server *Server
+	Scope  string
+	service.UnimplementedAdminServer
+}
+
+func (a *Admin) ListStreams(ctx context.Context, request *service.ListStreamsRequest) (*service.ListStreamsResponse, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &service.ListStreamsResponse{}
+	for _, l := range a.Server.live(request.ClientId) {
+		response.Streams = append(response.Streams, l.proto())
+	}
+
+	return response, nil
+}
+
+func (a *Admin) CloseStreams(ctx context.Context, request *service.CloseStreamsRequest) (*service.CloseStreamsResponse, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.StreamId == 0 && request.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "a stream_id or a client_id is needed")
+	}
+
+	reason := "closed by an administrator"
+	if request.Reason != "" {
+		reason = fmt.Sprintf("%s: %s", reason, request.Reason)
+	}
+
+	var closed int32
+	for _, l := range a.Server.live(request.ClientId) {
+		if request.StreamId != 0 && l.id != request.StreamId {
+			continue
+		}
+
+		select {
+		case l.closed <- status.Error(codes.Aborted, reason):
+			closed++
+		default:
+			// already being closed
+		}
+	}
+
+	record(ctx, audit.AdminAction, request.Reason, map[string]interface{}{
+		"action":    "close_streams",
+		"stream_id": request.StreamId,
+		"target":    request.ClientId,
+		"closed":    closed,
+	})
+
+	return &service.CloseStreamsResponse{Closed: closed}, nil
+}
+
+func (a *Admin) SetLogLevel(ctx context.Context, request *service.SetLogLevelRequest) (*service.SetLogLevelResponse, error) {
+	err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	level, err := log.ParseLevel(request.Level)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	previous := log.GetLevel()
+	log.SetLevel(level)
+
+	log.Infof("log level changed from %v to %v", previous, level)
+	record(ctx, audit.AdminAction, "", map[string]interface{}{"action": "set_log_level", "level": level.String()})
+
+	return &service.SetLogLevelResponse{PreviousLevel: previous.String()}, nil
+}
+
+func (a *Admin) authorize(ctx context.Context) error {
+	principal, ok := authentication.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing principal")
+	}
+
+	for _, scope := range principal.Scopes {
+		if scope == a.Scope {
+			return nil
+		}
+	}
+
+	record(ctx, audit.AuthFailure, "missing admin scope", nil)
+
+	return status.Errorf(codes.PermissionDenied, "the %s scope is needed", a.Scope)
+}
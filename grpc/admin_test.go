@@ -0,0 +1,74 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nearbyflights/nearbyflights/authentication"
+	service "github.com/nearbyflights/nearbyflights/proto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdmin(t *testing.T) {
+	server := &Server{}
+	admin := &Admin{Server: server, Scope: "nearbyflights:admin"}
+
+	server.register(authentication.NewContext(context.Background(), authentication.Principal{ID: "first"}), 1, "free")
+	server.register(authentication.NewContext(context.Background(), authentication.Principal{ID: "second"}), 2, "free")
+	third := server.register(authentication.NewContext(context.Background(), authentication.Principal{ID: "second"}), 3, "partner")
+	third.setOptions(&service.Options{Latitude: 1, Longitude: 2, Radius: 3, IntervalInSeconds: 4, AccessToken: "secret"})
+
+	ctx := authentication.NewContext(context.Background(), authentication.Principal{ID: "operator", Scopes: []string{"nearbyflights:admin"}})
+
+	_, err := admin.ListStreams(authentication.NewContext(context.Background(), authentication.Principal{ID: "someone"}), &service.ListStreamsRequest{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied without the admin scope, got %v", err)
+	}
+
+	listed, err := admin.ListStreams(ctx, &service.ListStreamsRequest{ClientId: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Streams) != 2 || listed.Streams[0].Id != 2 || listed.Streams[1].Id != 3 {
+		t.Fatalf("expected streams 2 and 3, got %v", listed.Streams)
+	}
+	if listed.Streams[1].Options.Radius != 3 || listed.Streams[1].Options.AccessToken != "" {
+		t.Errorf("expected the options without the token, got %v", listed.Streams[1].Options)
+	}
+
+	_, err = admin.CloseStreams(ctx, &service.CloseStreamsRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument without a target, got %v", err)
+	}
+
+	closed, err := admin.CloseStreams(ctx, &service.CloseStreamsRequest{ClientId: "second", Reason: "abuse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed.Closed != 2 {
+		t.Errorf("expected 2 closed streams, got %v", closed.Closed)
+	}
+
+	err = <-third.closed
+	if status.Code(err) != codes.Aborted || status.Convert(err).Message() != "closed by an administrator: abuse" {
+		t.Errorf("unexpected close error %v", err)
+	}
+
+	previous := log.GetLevel()
+	defer log.SetLevel(previous)
+
+	_, err = admin.SetLogLevel(ctx, &service.SetLogLevelRequest{Level: "loud"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an unknown level, got %v", err)
+	}
+
+	level, err := admin.SetLogLevel(ctx, &service.SetLogLevelRequest{Level: "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level.PreviousLevel != previous.String() || log.GetLevel() != log.DebugLevel {
+		t.Errorf("expected the level to change from %v to debug, got %v and %v", previous, level.PreviousLevel, log.GetLevel())
+	}
+}
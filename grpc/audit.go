@@ -0,0 +1,21 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/nearbyflights/nearbyflights/audit"
+	"github.com/nearbyflights/nearbyflights/authentication"
+)
+
+// record adds an event about the stream owning the context to the audit log.
+func record(ctx context.Context, eventType string, reason string, fields map[string]interface{}) {
+	principal, _ := authentication.FromContext(ctx)
+
+	audit.Record(audit.Event{
+		Type:     eventType,
+		ClientId: principal.ID,
+		Peer:     audit.Peer(ctx),
+		Reason:   reason,
+		Fields:   fields,
+	})
+}
@@ -0,0 +1,39 @@
+package grpc
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	activeStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nearbyflights_active_streams",
+		Help: "Receive streams currently open.",
+	})
+
+	finishedStreams = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nearbyflights_streams_finished_total",
+		Help: "Receive streams finished, by status code.",
+	}, []string{"code"})
+
+	optionsUpdates = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nearbyflights_options_updates_total",
+		Help: "Options messages received from clients.",
+	})
+
+	flightsSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nearbyflights_flights_sent_total",
+		Help: "Flight messages sent to clients.",
+	})
+
+	sendErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nearbyflights_send_errors_total",
+		Help: "Flight messages that couldn't be sent.",
+	})
+
+	messagesPerStream = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "nearbyflights_stream_messages_sent",
+		Help:    "Flight messages sent over the lifetime of a stream.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	})
+)
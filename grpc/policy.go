@@ -0,0 +1,34 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/nearbyflights/nearbyflights/authentication"
+	"github.com/nearbyflights/nearbyflights/policy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authorize resolves the tier of the stream principal and checks it may call the method. The
+// concurrent streams of the tier are capped by the limiter, along with every other stream limit.
+func (s *Server) authorize(ctx context.Context, method string) (policy.Tier, error) {
+	if s.Policy == nil {
+		return policy.Tier{}, nil
+	}
+
+	principal, ok := authentication.FromContext(ctx)
+	if !ok {
+		return policy.Tier{}, status.Error(codes.Unauthenticated, "missing principal")
+	}
+
+	tier, err := s.Policy.TierOf(principal)
+	if err != nil {
+		return policy.Tier{}, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if !tier.Allows(method) {
+		return policy.Tier{}, status.Errorf(codes.PermissionDenied, "%s is not allowed by the %s tier", method, tier.Name)
+	}
+
+	return tier, nil
+}
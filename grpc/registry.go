@@ -0,0 +1,104 @@
+package grpc
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/audit"
+	"github.com/nearbyflights/nearbyflights/authentication"
+	service "github.com/nearbyflights/nearbyflights/proto"
+)
+
+// liveStream is the entry of an open Receive stream in the registry.
+type liveStream struct {
+	id       uint64
+	clientId string
+	peer     string
+	tier     string
+	started  time.Time
+	sent     int64
+
+	mutex   sync.Mutex
+	options *service.Options
+
+	// closed receives the error ending the stream when an administrator closes it
+	closed chan error
+}
+
+func (l *liveStream) setOptions(options *service.Options) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.options = &service.Options{
+		IntervalInSeconds: options.IntervalInSeconds,
+		Latitude:          options.Latitude,
+		Longitude:         options.Longitude,
+		Radius:            options.Radius,
+	}
+}
+
+func (l *liveStream) proto() *service.Stream {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return &service.Stream{
+		Id:           l.id,
+		ClientId:     l.clientId,
+		Peer:         l.peer,
+		Tier:         l.tier,
+		Options:      l.options,
+		StartedAt:    l.started.Unix(),
+		MessagesSent: atomic.LoadInt64(&l.sent),
+	}
+}
+
+func (s *Server) register(ctx context.Context, id uint64, tier string) *liveStream {
+	principal, _ := authentication.FromContext(ctx)
+
+	l := &liveStream{
+		id:       id,
+		clientId: principal.ID,
+		peer:     audit.Peer(ctx),
+		tier:     tier,
+		started:  time.Now(),
+		closed:   make(chan error, 1),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.registry == nil {
+		s.registry = make(map[uint64]*liveStream)
+	}
+
+	s.registry[id] = l
+
+	return l
+}
+
+func (s *Server) unregister(id uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.registry, id)
+}
+
+// live returns the open streams of the client, or all of them when clientId is empty, oldest first.
+func (s *Server) live(clientId string) []*liveStream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var streams []*liveStream
+	for _, l := range s.registry {
+		if clientId == "" || l.clientId == clientId {
+			streams = append(streams, l)
+		}
+	}
+
+	sort.Slice(streams, func(i, j int) bool { return streams[i].id < streams[j].id })
+
+	return streams
+}
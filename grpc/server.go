@@ -2,106 +2,280 @@ package grpc
 
 import (
 	"context"
-	"errors"
 	"google.golang.org/grpc/health"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nearbyflights/nearbyflights/audit"
+	"github.com/nearbyflights/nearbyflights/authentication"
 	"github.com/nearbyflights/nearbyflights/db"
+	"github.com/nearbyflights/nearbyflights/logging"
+	"github.com/nearbyflights/nearbyflights/policy"
 	service "github.com/nearbyflights/nearbyflights/proto"
 	"github.com/nearbyflights/nearbyflights/schedule"
+	"github.com/nearbyflights/nearbyflights/tracing"
+	"github.com/nearbyflights/nearbyflights/watchdog"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+const (
+	receiveMethod        = "/proto.NearbyFlights/Receive"
+	receiveUpdatesMethod = "/proto.NearbyFlights/ReceiveUpdates"
+)
+
+// streamIds numbers the streams in the logs and the registry.
+var streamIds uint64
+
+var tracer = otel.Tracer("github.com/nearbyflights/nearbyflights/grpc")
+
 type Server struct {
 	HealthServer *health.Server
-	Options      db.ClientOptions
-	Context      context.Context
-	Wg			 *sync.WaitGroup
+	Scheduler    *schedule.Scheduler
+	// Policy limits streams by the tier of their principal, nil allows everything.
+	Policy *policy.Policy
+	// Authenticator checks tokens sent in the stream to refresh its session.
+	Authenticator authentication.Authenticator
+	// Reauthentication is the period between checks that the stream token was not revoked, zero disables them.
+	Reauthentication time.Duration
+	// Watchdog tells streams when the flights turn stale, nil never does.
+	Watchdog *watchdog.Watchdog
+	Context  context.Context
+	Wg       *sync.WaitGroup
 	service.UnimplementedNearbyFlightsServer
+
+	mutex    sync.Mutex
+	registry map[uint64]*liveStream
 }
 
+// Receive only sends flights, the status of the server is in the metadata of the stream.
 func (s *Server) Receive(stream service.NearbyFlights_ReceiveServer) error {
-	errorCh := make(chan error)
-	newOptions := make(chan schedule.Options)
-	client := db.NewClient(s.Options)
-	scheduler := schedule.Scheduler{Client: client}
+	return s.receive(receiveMethod, &flightStream{NearbyFlights_ReceiveServer: stream})
+}
+
+func (s *Server) ReceiveUpdates(stream service.NearbyFlights_ReceiveUpdatesServer) error {
+	return s.receive(receiveUpdatesMethod, updateStream{stream})
+}
+
+func (s *Server) receive(method string, stream receiveStream) error {
+	errorCh := make(chan error, 2)
+	subscriptions := make(chan *schedule.Subscription, 1)
+	sessions := make(chan session, 1)
+
+	principal, _ := authentication.FromContext(stream.Context())
+	id := atomic.AddUint64(&streamIds, 1)
+	ctx := logging.NewContext(stream.Context(), id, principal.ID)
+	logger := logging.FromContext(ctx)
+
+	tier, err := s.authorize(ctx, method)
+	if err != nil {
+		record(ctx, audit.AuthFailure, status.Convert(err).Message(), map[string]interface{}{"method": method})
+		return err
+	}
+
+	started := time.Now()
+	activeStreams.Inc()
+	defer activeStreams.Dec()
 
-	ctx := stream.Context()
+	live := s.register(ctx, id, tier.Name)
+	defer s.unregister(id)
+
+	record(ctx, audit.StreamStart, "", map[string]interface{}{"method": method, "tier": tier.Name})
 
 	s.Wg.Add(1)
 	go func() {
 		defer s.Wg.Done()
 
+		var subscription *schedule.Subscription
+		current := newSession(ctx)
+
 		for {
 			options, err := stream.Recv()
+			if err == io.EOF {
+				// the client won't change its options anymore, the flights keep coming
+				logger.Debug("client closed its side of the stream: finish receive routine")
+				return
+			}
 			if err != nil {
-				st, ok := status.FromError(err)
+				// the client is sending options faster than it is allowed to
+				if status.Code(err) == codes.ResourceExhausted {
+					errorCh <- err
+					return
+				}
 
-				if !ok {
-					log.Error(err)
-					continue
+				// Recv keeps failing once the stream is broken, so the routine ends with any error
+				logger.Debugf("stream closed: finish receive routine: %v", err)
+				return
+			}
+
+			if options.AccessToken != "" {
+				refreshed, err := s.refresh(ctx, current, options.AccessToken)
+				if err != nil {
+					errorCh <- err
+					return
 				}
 
-				if st.Code() == codes.Canceled {
-					log.Info("stream closed: finish receive routine")
+				current = refreshed
+
+				select {
+				case sessions <- current:
+				case <-ctx.Done():
 					return
 				}
-			}
 
-			if options == nil {
 				continue
 			}
 
-			log.Info("received new options from client")
+			logger.WithFields(log.Fields{
+				"latitude":  options.Latitude,
+				"longitude": options.Longitude,
+				"radius":    options.Radius,
+				"interval":  options.IntervalInSeconds,
+			}).Info("received new options from client")
+			optionsUpdates.Inc()
 
-			newOptions <- schedule.Options{
+			newOptions := schedule.Options{
 				Latitude:  options.Latitude,
 				Longitude: options.Longitude,
 				Radius:    options.Radius,
 				Interval:  time.Second * time.Duration(options.IntervalInSeconds),
 			}
+
+			record(ctx, audit.OptionsChanged, "", map[string]interface{}{
+				"latitude":  newOptions.Latitude,
+				"longitude": newOptions.Longitude,
+				"radius":    newOptions.Radius,
+				"interval":  newOptions.Interval.String(),
+			})
+
+			if reason := tier.Check(newOptions.Radius, newOptions.Interval); reason != "" {
+				errorCh <- status.Error(codes.PermissionDenied, reason)
+				return
+			}
+
+			live.setOptions(options)
+
+			if subscription == nil {
+				subscription = s.Scheduler.Subscribe(ctx, newOptions)
+				subscriptions <- subscription
+				continue
+			}
+
+			subscription.Update(newOptions)
 		}
 	}()
 
-	flights, err := scheduler.GetFlights(ctx, newOptions)
-	if err != nil {
-		return err
-	}
-
 	s.Wg.Add(1)
 	go func() {
 		defer s.Wg.Done()
 
+		var flights <-chan []db.Flight
+
+		current := newSession(ctx)
+		timer, expired := expiration(current)
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		var freshness <-chan watchdog.State
+		if s.Watchdog != nil {
+			states, unsubscribe := s.Watchdog.Subscribe()
+			defer unsubscribe()
+			freshness = states
+
+			// a stream opened while the flights are stale is told right away
+			if state := s.Watchdog.State(); state.Stale {
+				s.sendStatus(stream, logger, state)
+			}
+		}
+
+		var reauthenticate <-chan time.Time
+		if s.Reauthentication > 0 {
+			ticker := time.NewTicker(s.Reauthentication)
+			defer ticker.Stop()
+			reauthenticate = ticker.C
+		}
+
 		for {
 			select {
+			case subscription := <-subscriptions:
+				defer subscription.Cancel()
+				flights = subscription.Flights()
 			case flights := <-flights:
+				_, span := tracer.Start(ctx, "stream.Send", trace.WithAttributes(label.Int("flights", len(flights))))
+
 				for _, f := range flights {
-					stream.Send(&service.Flight{
+					err := stream.sendFlight(&service.Flight{
 						Latitude:  f.Latitude,
 						Longitude: f.Longitude,
 						Country:   f.Country,
 						CallSign:  f.CallSign,
 						Icao24:    f.Icao24,
 						Velocity:  f.Velocity})
+					if err != nil {
+						sendErrors.Inc()
+						tracing.Fail(span, err)
+						continue
+					}
+
+					flightsSent.Inc()
+					atomic.AddInt64(&live.sent, 1)
+				}
+
+				span.End()
+			case state := <-freshness:
+				s.sendStatus(stream, logger, state)
+			case current = <-sessions:
+				if timer != nil {
+					timer.Stop()
+				}
+				timer, expired = expiration(current)
+			case <-expired:
+				logger.Info("token expired: closing stream")
+				errorCh <- status.Error(codes.Unauthenticated, "token expired")
+				return
+			case <-reauthenticate:
+				err := s.reauthenticate(ctx, current)
+				if err != nil {
+					errorCh <- err
+					return
 				}
 			case <-s.Context.Done():
-				errorCh <- errors.New("server stopped")
+				s.sendDraining(stream, logger)
+				errorCh <- status.Error(codes.Unavailable, "server draining, reconnect")
 				return
 			case <-ctx.Done():
-				log.Info("stream closed: finish send routine")
+				logger.Debug("stream closed: finish send routine")
+				errorCh <- ctx.Err()
 				return
 			}
 		}
 	}()
 
-	error := <-errorCh
-	log.Error(error)
+	var error error
+	select {
+	case error = <-errorCh:
+	case error = <-live.closed:
+	}
+	logger.WithField("code", status.Code(error)).Infof("stream finished: %v", error)
+
+	stream.finish()
+
+	finishedStreams.WithLabelValues(status.Code(error).String()).Inc()
+	messagesPerStream.Observe(float64(atomic.LoadInt64(&live.sent)))
 
-	log.Info("closing db connection")
-	client.Close()
+	record(ctx, audit.StreamEnd, error.Error(), map[string]interface{}{
+		"code":     status.Code(error).String(),
+		"duration": time.Since(started).String(),
+	})
 
 	return error
 }
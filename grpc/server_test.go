@@ -19,6 +19,7 @@ import (
 	"github.com/nearbyflights/nearbyflights/authentication"
 	"github.com/nearbyflights/nearbyflights/db"
 	service "github.com/nearbyflights/nearbyflights/proto"
+	"github.com/nearbyflights/nearbyflights/schedule"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/test/bufconn"
@@ -27,7 +28,7 @@ import (
 const bufSize = 1024 * 1024
 
 var listener *bufconn.Listener
-var client db.Client
+var client *db.Client
 
 // coordinates in the middle of the Pacific Ocean to avoid bumping with a real flight from the database
 var (
@@ -43,7 +44,7 @@ func init() {
 {
     "active": true,
     "client_id": "my-client",
-    "exp": 1527078658,
+    "exp": 4102444800,
     "iat": 1527075058,
     "iss": "http://127.0.0.1:4444/",
     "sub": "my-client",
@@ -70,16 +71,24 @@ func init() {
 	client.AddTestFlight(db.Flight{Geometry: types.Q(fmt.Sprintf("ST_SetSRID(ST_MakePoint(%v, %v),4326)", longitude, latitude)), Latitude: latitude, Longitude: longitude, Country: "BR", Icao24: "123456", Velocity: 10})
 
 	// following logic will instantiate and serve the gRPC server
-	server := &Server{UnimplementedNearbyFlightsServer: service.UnimplementedNearbyFlightsServer{}, Options: database, Context: context.Background(), Wg: wg}
+	scheduler := schedule.New(client, 1)
+	go scheduler.Run(context.Background())
+
+	server := &Server{UnimplementedNearbyFlightsServer: service.UnimplementedNearbyFlightsServer{}, Scheduler: scheduler, Context: context.Background(), Wg: wg}
 
 	cert, err := credentials.NewServerTLSFromFile("../proto/x509/server.crt", "../proto/x509/server.key")
 	if err != nil {
 		log.Fatalf("error loading TLS certificate %v", err)
 	}
 
+	introspector, err := authentication.NewIntrospector(authentication.IntrospectorOptions{Url: ts.URL, Timeout: time.Second})
+	if err != nil {
+		log.Fatalf("error creating introspector %v", err)
+	}
+
 	opts := []grpc.ServerOption{
 		// Intercept request to check the token.
-		grpc.StreamInterceptor(authentication.NewAuthInterceptor(ts.URL)),
+		grpc.StreamInterceptor(authentication.NewAuthInterceptor(authentication.TokenAuthenticator{Introspector: introspector})),
 		// Enable TLS for all incoming connections.
 		grpc.Creds(cert),
 	}
@@ -0,0 +1,98 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/audit"
+	"github.com/nearbyflights/nearbyflights/authentication"
+	"github.com/nearbyflights/nearbyflights/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// session is the credentials currently backing a stream.
+type session struct {
+	token     string
+	principal authentication.Principal
+}
+
+func newSession(ctx context.Context) session {
+	principal, _ := authentication.FromContext(ctx)
+	return session{token: authentication.TokenFromContext(ctx), principal: principal}
+}
+
+// refresh authenticates the stream again with the token sent by the client, the token must
+// belong to the principal that opened the stream. When the token can't be checked right now the
+// stream keeps its current session and the client may send the token again.
+func (s *Server) refresh(ctx context.Context, current session, token string) (session, error) {
+	if s.Authenticator == nil {
+		return session{}, status.Error(codes.Unimplemented, "token refresh is not supported")
+	}
+
+	principal, err := s.Authenticator.Authenticate(authentication.WithToken(ctx, token))
+	if authentication.IsUnavailable(err) {
+		logging.FromContext(ctx).Warnf("error checking the refreshed token, keeping the current session: %v", err)
+		return current, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Errorf("error refreshing token: %v", err)
+		record(ctx, audit.AuthFailure, authentication.Redact(err.Error(), token), map[string]interface{}{"credential": audit.Fingerprint(token)})
+		return session{}, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	if principal.ID != current.principal.ID {
+		record(ctx, audit.AuthFailure, "token belongs to another client", map[string]interface{}{"credential": audit.Fingerprint(token)})
+		return session{}, status.Error(codes.PermissionDenied, "token belongs to another client")
+	}
+
+	logging.FromContext(ctx).Infof("token refreshed, session valid until %v", principal.ExpiresAt)
+
+	if token != current.token {
+		record(ctx, audit.TokenRefreshed, "", map[string]interface{}{"credential": audit.Fingerprint(token), "expires_at": principal.ExpiresAt})
+	}
+
+	return session{token: token, principal: principal}, nil
+}
+
+// reauthenticate checks the credentials of the stream again, whatever their type, without cached
+// answers. Only credentials found to be invalid or revoked fail it, a check that couldn't be
+// completed leaves the stream open until the next one.
+func (s *Server) reauthenticate(ctx context.Context, current session) error {
+	if s.Authenticator == nil {
+		return nil
+	}
+
+	authCtx := authentication.WithoutCache(ctx)
+	if current.token != "" {
+		authCtx = authentication.WithToken(authCtx, current.token)
+	}
+
+	principal, err := s.Authenticator.Authenticate(authCtx)
+	if authentication.IsUnavailable(err) || ctx.Err() != nil {
+		logging.FromContext(ctx).Warnf("error reauthenticating, keeping the stream open: %v", err)
+		return nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Infof("credentials no longer valid: %v", err)
+		record(ctx, audit.AuthFailure, authentication.Redact(err.Error(), current.token), map[string]interface{}{"reauthentication": true})
+		return status.Error(codes.Unauthenticated, "credentials revoked")
+	}
+
+	if principal.ID != current.principal.ID {
+		record(ctx, audit.AuthFailure, "credentials belong to another client", map[string]interface{}{"reauthentication": true})
+		return status.Error(codes.PermissionDenied, "credentials belong to another client")
+	}
+
+	return nil
+}
+
+// expiration fires when the session expires, a nil channel never fires.
+func expiration(current session) (*time.Timer, <-chan time.Time) {
+	if current.principal.ExpiresAt.IsZero() {
+		return nil, nil
+	}
+
+	timer := time.NewTimer(time.Until(current.principal.ExpiresAt))
+	return timer, timer.C
+}
@@ -0,0 +1,154 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/authentication"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// introspectionServer answers for the tokens valid, other and revoked, and fails while down is set.
+func introspectionServer(t *testing.T, down *int32, revoked *int32) *authentication.Introspector {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		exp := time.Now().Add(time.Hour).Unix()
+		switch token := r.FormValue("token"); {
+		case token == "revoked" || atomic.LoadInt32(revoked) == 1:
+			fmt.Fprint(w, `{"active": false}`)
+		case token == "other":
+			fmt.Fprintf(w, `{"active": true, "sub": "other-client", "exp": %v}`, exp)
+		default:
+			fmt.Fprintf(w, `{"active": true, "sub": "my-client", "exp": %v}`, exp)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	introspector, err := authentication.NewIntrospector(authentication.IntrospectorOptions{Url: ts.URL, Timeout: time.Second, CacheTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return introspector
+}
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestRefresh(t *testing.T) {
+	var down, revoked int32
+	server := &Server{Authenticator: authentication.TokenAuthenticator{Introspector: introspectionServer(t, &down, &revoked)}}
+
+	ctx := withToken("first")
+	current := session{token: "first", principal: authentication.Principal{ID: "my-client"}}
+
+	refreshed, err := server.refresh(ctx, current, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.token != "second" || refreshed.principal.ExpiresAt.IsZero() {
+		t.Errorf("expected the session of the new token with its expiration, got %+v", refreshed)
+	}
+
+	_, err = server.refresh(ctx, current, "other")
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for the token of another client, got %v", err)
+	}
+
+	_, err = server.refresh(ctx, current, "revoked")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated for a revoked token, got %v", err)
+	}
+
+	atomic.StoreInt32(&down, 1)
+
+	kept, err := server.refresh(ctx, current, "third")
+	if err != nil || kept.token != "first" {
+		t.Errorf("expected the current session to be kept while the token can't be checked, got %+v %v", kept, err)
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	timer, expired := expiration(session{})
+	if timer != nil || expired != nil {
+		t.Error("a session without expiration should never expire")
+	}
+
+	timer, expired = expiration(session{principal: authentication.Principal{ExpiresAt: time.Now().Add(10 * time.Millisecond)}})
+	defer timer.Stop()
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Error("expected the session to expire")
+	}
+}
+
+func TestReauthenticate(t *testing.T) {
+	var down, revoked int32
+	introspector := introspectionServer(t, &down, &revoked)
+
+	key, keyHash, err := authentication.NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []authentication.APIKey{{Hash: keyHash, Owner: "my-client"}}
+	apiKeys, err := authentication.NewAPIKeyAuthenticator(authentication.APIKeySourceFunc(func() ([]authentication.APIKey, error) {
+		return keys, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{Authenticator: authentication.Chain{authentication.TokenAuthenticator{Introspector: introspector}, apiKeys}}
+	current := session{token: "valid", principal: authentication.Principal{ID: "my-client"}}
+
+	// the stream authenticated with the token, which is cached from now on
+	if _, err := server.Authenticator.Authenticate(withToken("valid")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.reauthenticate(withToken("valid"), current); err != nil {
+		t.Errorf("expected a valid token to pass: %v", err)
+	}
+
+	atomic.StoreInt32(&down, 1)
+	if err := server.reauthenticate(withToken("valid"), current); err != nil {
+		t.Errorf("expected the stream to stay open while the token can't be checked: %v", err)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	atomic.StoreInt32(&revoked, 1)
+	if err := server.reauthenticate(withToken("valid"), current); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated for a revoked token despite the cache, got %v", err)
+	}
+
+	keyCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", key))
+	keySession := session{principal: authentication.Principal{ID: "my-client"}}
+
+	if err := server.reauthenticate(keyCtx, keySession); err != nil {
+		t.Errorf("expected a valid API key to pass: %v", err)
+	}
+
+	keys = nil
+	if err := apiKeys.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.reauthenticate(keyCtx, keySession); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated for a removed API key, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	service "github.com/nearbyflights/nearbyflights/proto"
+	"github.com/nearbyflights/nearbyflights/watchdog"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata telling Receive clients the status of the server, as ReceiveUpdates streams get it in messages.
+const (
+	freshnessKey  = "nearbyflights-freshness"
+	lastUpdateKey = "nearbyflights-last-update"
+	drainingKey   = "nearbyflights-draining"
+)
+
+// receiveStream is a Receive or a ReceiveUpdates stream.
+type receiveStream interface {
+	Context() context.Context
+	Recv() (*service.Options, error)
+	sendFlight(flight *service.Flight) error
+	sendStatus(status *service.Status) error
+	// finish runs before the stream is closed.
+	finish()
+}
+
+// flightStream is a Receive stream. Its clients read every message as a flight, so it carries the
+// status in metadata instead: the headers get it while no flight was sent and the trailers get the
+// latest one, along with the draining notice, when the stream closes.
+type flightStream struct {
+	service.NearbyFlights_ReceiveServer
+
+	mutex   sync.Mutex
+	sent    bool
+	trailer metadata.MD
+}
+
+func (f *flightStream) sendFlight(flight *service.Flight) error {
+	f.mutex.Lock()
+	f.sent = true
+	f.mutex.Unlock()
+
+	return f.Send(flight)
+}
+
+func (f *flightStream) sendStatus(status *service.Status) error {
+	md := statusMetadata(status)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.trailer == nil {
+		f.trailer = metadata.MD{}
+	}
+	for key, values := range md {
+		f.trailer[key] = values
+	}
+
+	if f.sent {
+		return nil
+	}
+
+	// headers can only be sent once, later statuses wait for the trailers
+	f.sent = true
+	return f.SendHeader(md)
+}
+
+func (f *flightStream) finish() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.trailer != nil {
+		f.SetTrailer(f.trailer)
+	}
+}
+
+func statusMetadata(status *service.Status) metadata.MD {
+	if status.Draining {
+		return metadata.Pairs(drainingKey, status.Message)
+	}
+
+	return metadata.Pairs(
+		freshnessKey, strings.ToLower(status.Freshness.String()),
+		lastUpdateKey, strconv.FormatInt(status.LastUpdate, 10),
+	)
+}
+
+type updateStream struct {
+	service.NearbyFlights_ReceiveUpdatesServer
+}
+
+func (u updateStream) sendFlight(flight *service.Flight) error {
+	return u.Send(&service.Update{Update: &service.Update_Flight{Flight: flight}})
+}
+
+func (u updateStream) sendStatus(status *service.Status) error {
+	return u.Send(&service.Update{Update: &service.Update_Status{Status: status}})
+}
+
+func (u updateStream) finish() {}
+
+// sendStatus tells the client whether the flights it receives are stale.
+func (s *Server) sendStatus(stream receiveStream, logger *log.Entry, state watchdog.State) {
+	status := &service.Status{Freshness: service.Status_FRESH, Message: "flight data is up to date"}
+
+	if !state.LastUpdate.IsZero() {
+		status.LastUpdate = state.LastUpdate.Unix()
+	}
+
+	if state.Stale {
+		status.Freshness = service.Status_STALE
+		status.Message = "flight data is stale, positions may be outdated"
+		if !state.LastUpdate.IsZero() {
+			status.Message = fmt.Sprintf("flight data is stale, last updated at %v", state.LastUpdate.UTC().Format(time.RFC3339))
+		}
+	}
+
+	err := stream.sendStatus(status)
+	if err != nil {
+		logger.Errorf("error sending status: %v", err)
+	}
+}
+
+// sendDraining tells the client the server is shutting down and it should reconnect.
+func (s *Server) sendDraining(stream receiveStream, logger *log.Entry) {
+	err := stream.sendStatus(&service.Status{Draining: true, Message: "server draining, reconnect"})
+	if err != nil {
+		logger.Errorf("error sending draining notice: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package grpc
+
+import (
+	"reflect"
+	"testing"
+
+	service "github.com/nearbyflights/nearbyflights/proto"
+	"google.golang.org/grpc/metadata"
+)
+
+type sentFlights struct {
+	service.NearbyFlights_ReceiveServer
+	sent    []*service.Flight
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *sentFlights) Send(flight *service.Flight) error {
+	s.sent = append(s.sent, flight)
+	return nil
+}
+
+func (s *sentFlights) SendHeader(md metadata.MD) error {
+	s.header = md
+	return nil
+}
+
+func (s *sentFlights) SetTrailer(md metadata.MD) {
+	s.trailer = md
+}
+
+type sentUpdates struct {
+	service.NearbyFlights_ReceiveUpdatesServer
+	sent []*service.Update
+}
+
+func (s *sentUpdates) Send(update *service.Update) error {
+	s.sent = append(s.sent, update)
+	return nil
+}
+
+func TestReceiveStreams(t *testing.T) {
+	flights := &sentFlights{}
+	stream := &flightStream{NearbyFlights_ReceiveServer: flights}
+
+	if err := stream.sendStatus(&service.Status{Freshness: service.Status_STALE, LastUpdate: 1600000000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.sendFlight(&service.Flight{Icao24: "abc123"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.sendStatus(&service.Status{Freshness: service.Status_FRESH, LastUpdate: 1600000060}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.sendStatus(&service.Status{Draining: true, Message: "server draining, reconnect"}); err != nil {
+		t.Fatal(err)
+	}
+	stream.finish()
+
+	if len(flights.sent) != 1 || flights.sent[0].Icao24 != "abc123" {
+		t.Errorf("expected Receive to only get the flight, got %v", flights.sent)
+	}
+	if !reflect.DeepEqual(flights.header, metadata.Pairs(freshnessKey, "stale", lastUpdateKey, "1600000000")) {
+		t.Errorf("expected the status sent before the first flight in the headers, got %v", flights.header)
+	}
+	if !reflect.DeepEqual(flights.trailer, metadata.Pairs(freshnessKey, "fresh", lastUpdateKey, "1600000060", drainingKey, "server draining, reconnect")) {
+		t.Errorf("expected the latest status and the draining notice in the trailers, got %v", flights.trailer)
+	}
+
+	updates := &sentUpdates{}
+	updatesStream := updateStream{updates}
+
+	if err := updatesStream.sendFlight(&service.Flight{Icao24: "abc123"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updatesStream.sendStatus(&service.Status{Draining: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates.sent) != 2 || updates.sent[0].GetFlight().GetIcao24() != "abc123" || !updates.sent[1].GetStatus().GetDraining() {
+		t.Errorf("expected ReceiveUpdates to get the flight and then the status, got %v", updates.sent)
+	}
+}
@@ -0,0 +1,255 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	grpcStatus "google.golang.org/grpc/status"
+)
+
+// Check is a dependency probed periodically. Critical checks decide whether the server is ready.
+type Check struct {
+	Name     string
+	Critical bool
+	Probe    func(ctx context.Context) error
+}
+
+type result struct {
+	Healthy bool      `json:"healthy"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// Checker runs the checks and publishes their results as gRPC health statuses, one per check named
+// after the service and the check, and the overall status under the service name.
+type Checker struct {
+	service  string
+	server   *health.Server
+	checks   []Check
+	interval time.Duration
+	timeout  time.Duration
+
+	mutex    sync.RWMutex
+	results  map[string]result
+	stopping bool
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func New(service string, server *health.Server, interval time.Duration, timeout time.Duration, checks ...Check) *Checker {
+	c := &Checker{service: service, server: server, checks: checks, interval: interval, timeout: timeout, results: make(map[string]result), closed: make(chan struct{})}
+
+	// nothing is ready until the first round of checks
+	server.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	for _, check := range checks {
+		server.SetServingStatus(c.name(check), grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	}
+
+	return c
+}
+
+// Run checks every dependency each interval until the context is done.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.CheckAll(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CheckAll probes every dependency at the same time and updates the statuses.
+func (c *Checker) CheckAll(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			err := check.Probe(probeCtx)
+			c.set(check, err)
+		}(check)
+	}
+
+	wg.Wait()
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !c.stopping {
+		c.server.SetServingStatus(c.service, status(c.ready()))
+	}
+}
+
+// Shutdown reports the server as not serving from now on, whatever the checks say.
+func (c *Checker) Shutdown() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.stopping = true
+	c.server.Shutdown()
+}
+
+// Close ends every Watch call of the health server, which would otherwise keep a graceful stop
+// waiting until the clients hang up.
+func (c *Checker) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+}
+
+// Server is the gRPC health service, to be registered instead of the health server so Close can
+// end its Watch calls.
+func (c *Checker) Server() grpc_health_v1.HealthServer {
+	return healthServer{Server: c.server, closed: c.closed}
+}
+
+type healthServer struct {
+	*health.Server
+	closed chan struct{}
+}
+
+func (h healthServer) Watch(request *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-h.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := h.Server.Watch(request, &watchStream{Health_WatchServer: stream, ctx: ctx})
+
+	select {
+	case <-h.closed:
+		return grpcStatus.Error(codes.Unavailable, "server shutting down")
+	default:
+		return err
+	}
+}
+
+// watchStream is a Watch call whose context is also done once the checker is closed.
+type watchStream struct {
+	grpc_health_v1.Health_WatchServer
+	ctx context.Context
+}
+
+func (w *watchStream) Context() context.Context {
+	return w.ctx
+}
+
+func (c *Checker) set(check Check, err error) {
+	r := result{Healthy: err == nil, Checked: time.Now()}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	previous, ok := c.results[check.Name]
+	if !ok || previous.Healthy != r.Healthy {
+		if err != nil {
+			log.Warnf("health check %s failed: %v", check.Name, err)
+		} else {
+			log.Infof("health check %s passed", check.Name)
+		}
+	}
+
+	c.results[check.Name] = r
+
+	if !c.stopping {
+		c.server.SetServingStatus(c.name(check), status(r.Healthy))
+	}
+}
+
+// ready must be called holding the mutex.
+func (c *Checker) ready() bool {
+	if c.stopping {
+		return false
+	}
+
+	for _, check := range c.checks {
+		if check.Critical && !c.results[check.Name].Healthy {
+			return false
+		}
+	}
+
+	return true
+}
+
+// degraded tells whether a non-critical check failed, the server is still ready but not at its
+// best. It must be called holding the mutex.
+func (c *Checker) degraded() bool {
+	for _, check := range c.checks {
+		if !check.Critical && !c.results[check.Name].Healthy {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Checker) name(check Check) string {
+	return c.service + "." + check.Name
+}
+
+// Livez answers 200 while the process is able to serve HTTP at all.
+func (c *Checker) Livez(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// Readyz answers 200 when every critical check passed and 503 otherwise, with the result of each
+// check and whether the server is degraded by a failed non-critical check.
+func (c *Checker) Readyz(w http.ResponseWriter, _ *http.Request) {
+	c.mutex.RLock()
+	ready := c.ready()
+	body, err := json.Marshal(struct {
+		Ready    bool              `json:"ready"`
+		Degraded bool              `json:"degraded"`
+		Checks   map[string]result `json:"checks"`
+	}{ready, c.degraded(), c.results})
+	c.mutex.RUnlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_, _ = w.Write(append(body, '\n'))
+}
+
+func status(healthy bool) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if healthy {
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+
+	return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+}
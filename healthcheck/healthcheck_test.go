@@ -0,0 +1,77 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func serving(t *testing.T, server *health.Server, service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	response, err := server.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response.Status
+}
+
+func TestCheckAll(t *testing.T) {
+	var databaseErr error
+	server := health.NewServer()
+
+	checker := New("nearbyflights", server, time.Minute, time.Second,
+		Check{Name: "database", Critical: true, Probe: func(context.Context) error { return databaseErr }},
+		Check{Name: "freshness", Probe: func(context.Context) error { return errors.New("stale") }},
+	)
+
+	if serving(t, server, "nearbyflights") != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Error("server should not be serving before the first checks")
+	}
+
+	checker.CheckAll(context.Background())
+
+	if serving(t, server, "nearbyflights") != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Error("failed non-critical checks should not make the server unready")
+	}
+
+	if serving(t, server, "nearbyflights.freshness") != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Error("failed check should have its own status")
+	}
+
+	recorder := httptest.NewRecorder()
+	checker.Readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"degraded":true`) {
+		t.Errorf("readyz should be ready but degraded, got %v %s", recorder.Code, recorder.Body)
+	}
+
+	databaseErr = errors.New("connection refused")
+	checker.CheckAll(context.Background())
+
+	if serving(t, server, "nearbyflights") != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Error("failed critical checks should make the server unready")
+	}
+
+	recorder = httptest.NewRecorder()
+	checker.Readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz should fail, got %v", recorder.Code)
+	}
+
+	databaseErr = nil
+	checker.CheckAll(context.Background())
+	checker.Shutdown()
+	checker.CheckAll(context.Background())
+
+	recorder = httptest.NewRecorder()
+	checker.Readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz should fail while shutting down, got %v", recorder.Code)
+	}
+}
@@ -0,0 +1,191 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/bbox"
+	"github.com/nearbyflights/nearbyflights/db"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+// searches covering more tiles than this go straight to the store
+const maxTiles = 64
+
+type tile struct {
+	x int
+	y int
+}
+
+type entry struct {
+	cycle   int64
+	flights []db.Flight
+}
+
+// Hub splits searches into fixed geographic tiles and fetches each tile from the store at most
+// once per refresh cycle. Every stream whose search area covers a tile shares that fetch and
+// the results are filtered down to each stream's own bounding box in memory.
+type Hub struct {
+	source   db.Store
+	tileSize float64
+	cycle    time.Duration
+	group    singleflight.Group
+	mutex    sync.Mutex
+	tiles    map[tile]entry
+	swept    int64
+}
+
+func New(source db.Store, tileSize float64, cycle time.Duration) *Hub {
+	if tileSize <= 0 {
+		tileSize = 1
+	}
+
+	if cycle <= 0 {
+		cycle = time.Second
+	}
+
+	return &Hub{source: source, tileSize: tileSize, cycle: cycle, tiles: make(map[tile]entry)}
+}
+
+func (h *Hub) GetFlights(ctx context.Context, box bbox.BoundingBox) ([]db.Flight, error) {
+	min := h.tileOf(box.MinLatitude, box.MinLongitude)
+	max := h.tileOf(box.MaxLatitude, box.MaxLongitude)
+
+	if (max.x-min.x+1)*(max.y-min.y+1) > maxTiles {
+		return h.source.GetFlights(ctx, box)
+	}
+
+	cycle := time.Now().UnixNano() / int64(h.cycle)
+	h.sweep(cycle)
+
+	// flights on a tile border are returned for both tiles
+	seen := make(map[string]bool)
+	flights := make([]db.Flight, 0)
+
+	for x := min.x; x <= max.x; x++ {
+		for y := min.y; y <= max.y; y++ {
+			tileFlights, err := h.fetch(ctx, tile{x, y}, cycle)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, f := range tileFlights {
+				if seen[f.Icao24] || !inside(f, box) {
+					continue
+				}
+
+				seen[f.Icao24] = true
+				flights = append(flights, f)
+			}
+		}
+	}
+
+	return flights, nil
+}
+
+func (h *Hub) GetAllFlights() ([]db.Flight, error) {
+	return h.source.GetAllFlights()
+}
+
+// LastUpdate returns the older of the last update of the store and the start of the current
+// cycle, tiles are fetched at most once per cycle so they may miss the updates made since.
+func (h *Hub) LastUpdate(ctx context.Context) (time.Time, error) {
+	freshness, ok := h.source.(db.Freshness)
+	if !ok {
+		return time.Time{}, errors.New("the store doesn't track updates")
+	}
+
+	updated, err := freshness.LastUpdate(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	cycleStart := time.Unix(0, time.Now().UnixNano()/int64(h.cycle)*int64(h.cycle))
+	if updated.Before(cycleStart) {
+		return updated, nil
+	}
+
+	return cycleStart, nil
+}
+
+func (h *Hub) Close() {
+	h.source.Close()
+}
+
+// fetch returns the flights of a tile for the given cycle, concurrent fetches of the same tile
+// wait for a single query to the store. The query keeps the trace of the stream that started
+// it but not its cancellation, as other streams wait for the same result.
+func (h *Hub) fetch(ctx context.Context, t tile, cycle int64) ([]db.Flight, error) {
+	h.mutex.Lock()
+	e, ok := h.tiles[t]
+	h.mutex.Unlock()
+
+	if ok && e.cycle == cycle {
+		return e.flights, nil
+	}
+
+	flights, err, _ := h.group.Do(fmt.Sprintf("%d:%d:%d", t.x, t.y, cycle), func() (interface{}, error) {
+		flights, err := h.source.GetFlights(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), h.bounds(t))
+		if err != nil {
+			return nil, err
+		}
+
+		h.mutex.Lock()
+		h.tiles[t] = entry{cycle: cycle, flights: flights}
+		h.mutex.Unlock()
+
+		return flights, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return flights.([]db.Flight), nil
+}
+
+// sweep drops the tiles nobody asked for during the previous cycle, once per cycle.
+func (h *Hub) sweep(cycle int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.swept == cycle {
+		return
+	}
+
+	for t, e := range h.tiles {
+		if e.cycle < cycle-1 {
+			delete(h.tiles, t)
+		}
+	}
+
+	h.swept = cycle
+
+	log.Debugf("%v tile(s) cached", len(h.tiles))
+}
+
+func (h *Hub) tileOf(latitude float64, longitude float64) tile {
+	return tile{
+		x: int(math.Floor(longitude / h.tileSize)),
+		y: int(math.Floor(latitude / h.tileSize)),
+	}
+}
+
+func (h *Hub) bounds(t tile) bbox.BoundingBox {
+	return bbox.BoundingBox{
+		MinLatitude:  float64(t.y) * h.tileSize,
+		MinLongitude: float64(t.x) * h.tileSize,
+		MaxLatitude:  float64(t.y+1) * h.tileSize,
+		MaxLongitude: float64(t.x+1) * h.tileSize,
+	}
+}
+
+func inside(f db.Flight, box bbox.BoundingBox) bool {
+	return f.Latitude >= box.MinLatitude && f.Latitude <= box.MaxLatitude &&
+		f.Longitude >= box.MinLongitude && f.Longitude <= box.MaxLongitude
+}
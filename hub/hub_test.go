@@ -0,0 +1,96 @@
+package hub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/bbox"
+	"github.com/nearbyflights/nearbyflights/db"
+)
+
+type store struct {
+	mutex    sync.Mutex
+	flights  []db.Flight
+	searches int
+}
+
+func (s *store) GetFlights(context.Context, bbox.BoundingBox) ([]db.Flight, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.searches++
+	return s.flights, nil
+}
+
+func (s *store) GetAllFlights() ([]db.Flight, error) {
+	return s.flights, nil
+}
+
+func (s *store) Close() {}
+
+func TestGetFlights_SharedTile(t *testing.T) {
+	source := &store{flights: []db.Flight{
+		{Icao24: "congonhas", Latitude: -23.63, Longitude: -46.66},
+		{Icao24: "guarulhos", Latitude: -23.43, Longitude: -46.47},
+	}}
+	hub := New(source, 1, time.Hour)
+
+	// both searches sit in the same 1 degree tile
+	congonhas, _ := hub.GetFlights(context.Background(), bbox.NewBoundingBox(-23.627238, -46.655919, 5000))
+	guarulhos, _ := hub.GetFlights(context.Background(), bbox.NewBoundingBox(-23.435556, -46.473056, 5000))
+
+	if source.searches != 1 {
+		t.Errorf("tile should be fetched once, got %v searches", source.searches)
+	}
+
+	if len(congonhas) != 1 || congonhas[0].Icao24 != "congonhas" {
+		t.Errorf("unexpected flights near Congonhas: %v", congonhas)
+	}
+
+	if len(guarulhos) != 1 || guarulhos[0].Icao24 != "guarulhos" {
+		t.Errorf("unexpected flights near Guarulhos: %v", guarulhos)
+	}
+}
+
+func TestGetFlights_NewCycle(t *testing.T) {
+	source := &store{}
+	hub := New(source, 1, time.Nanosecond)
+
+	_, _ = hub.GetFlights(context.Background(), bbox.NewBoundingBox(-23.627238, -46.655919, 5000))
+	_, _ = hub.GetFlights(context.Background(), bbox.NewBoundingBox(-23.627238, -46.655919, 5000))
+
+	if source.searches != 2 {
+		t.Errorf("tile should be fetched again in a new cycle, got %v searches", source.searches)
+	}
+}
+
+type freshStore struct {
+	store
+	lastUpdate time.Time
+}
+
+func (s *freshStore) LastUpdate(context.Context) (time.Time, error) {
+	return s.lastUpdate, nil
+}
+
+func TestLastUpdate(t *testing.T) {
+	source := &freshStore{lastUpdate: time.Now()}
+	h := New(source, 1, time.Hour)
+
+	updated, err := h.LastUpdate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !updated.Before(source.lastUpdate) || time.Since(updated) > time.Hour {
+		t.Errorf("expected the start of the current cycle, got %v", updated)
+	}
+
+	source.lastUpdate = time.Now().Add(-2 * time.Hour)
+	updated, _ = h.LastUpdate(context.Background())
+	if !updated.Equal(source.lastUpdate) {
+		t.Errorf("expected the store update time %v, got %v", source.lastUpdate, updated)
+	}
+}
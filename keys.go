@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/authentication"
+	"github.com/nearbyflights/nearbyflights/db"
+)
+
+const keysUsage = `usage: nearbyflights keys <command>
+
+commands:
+  create -owner <owner> [-scopes <scope,...>] [-tier <tier>]
+  list
+  rotate <id>
+  revoke <id>`
+
+// databaseAPIKeys loads the API keys that were not revoked from the api_keys table and records their use.
+type databaseAPIKeys struct {
+	client *db.Client
+}
+
+func (d databaseAPIKeys) LoadAPIKeys() ([]authentication.APIKey, error) {
+	rows, err := d.client.GetAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]authentication.APIKey, 0, len(rows))
+	for _, r := range rows {
+		keys = append(keys, authentication.APIKey{Hash: r.KeyHash, Owner: r.Owner, Scopes: r.Scopes, Tier: r.Tier})
+	}
+
+	return keys, nil
+}
+
+func (d databaseAPIKeys) APIKeysUsed(hashes []string) error {
+	return d.client.TouchAPIKeys(hashes)
+}
+
+// apiKeyStore is the part of db.Client used by the keys commands.
+type apiKeyStore interface {
+	CreateAPIKey(key *db.APIKey) error
+	ListAPIKeys() ([]db.APIKey, error)
+	RotateAPIKey(id int, keyHash string) (db.APIKey, error)
+	RevokeAPIKey(id int) error
+}
+
+func newAPIKeysClient(c Configuration) *db.Client {
+	return db.NewClient(db.ClientOptions{
+		Address:  c.PostgresUrl,
+		User:     c.User,
+		Password: c.Password,
+		Database: c.DatabaseName,
+	})
+}
+
+// keys runs the API key management commands and returns the exit code.
+func keys(c Configuration, args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, keysUsage)
+		return 2
+	}
+
+	client := newAPIKeysClient(c)
+	defer client.Close()
+
+	err := client.MigrateAPIKeys()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return runKeys(client, os.Stdout, args)
+}
+
+func runKeys(store apiKeyStore, out io.Writer, args []string) int {
+	var err error
+	switch args[0] {
+	case "create":
+		err = createKey(store, out, args[1:])
+	case "list":
+		err = listKeys(store, out)
+	case "rotate":
+		err = rotateKey(store, out, args[1:])
+	case "revoke":
+		err = revokeKey(store, out, args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, keysUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func createKey(store apiKeyStore, out io.Writer, args []string) error {
+	flags := flag.NewFlagSet("keys create", flag.ContinueOnError)
+	owner := flags.String("owner", "", "client ID given to streams authenticated with the key")
+	scopes := flags.String("scopes", "", "comma separated scopes granted to the key")
+	tier := flags.String("tier", "", "tier of the key")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *owner == "" {
+		return fmt.Errorf("the key needs an -owner")
+	}
+
+	secret, hash, err := authentication.NewAPIKey()
+	if err != nil {
+		return err
+	}
+
+	key := db.APIKey{KeyHash: hash, Owner: *owner, Scopes: splitList(*scopes), Tier: *tier}
+	err = store.CreateAPIKey(&key)
+	if err != nil {
+		return fmt.Errorf("error creating API key: %v", err)
+	}
+
+	printSecret(out, key.Id, secret)
+
+	return nil
+}
+
+func listKeys(store apiKeyStore, out io.Writer) error {
+	rows, err := store.ListAPIKeys()
+	if err != nil {
+		return fmt.Errorf("error listing API keys: %v", err)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOWNER\tSCOPES\tTIER\tCREATED\tLAST USED\tREVOKED")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%v\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Id, r.Owner, strings.Join(r.Scopes, ","), r.Tier, formatTime(r.CreatedAt), formatTime(r.LastUsedAt), formatTime(r.RevokedAt))
+	}
+
+	return w.Flush()
+}
+
+func rotateKey(store apiKeyStore, out io.Writer, args []string) error {
+	id, err := keyId(args)
+	if err != nil {
+		return err
+	}
+
+	secret, hash, err := authentication.NewAPIKey()
+	if err != nil {
+		return err
+	}
+
+	key, err := store.RotateAPIKey(id, hash)
+	if err != nil {
+		return fmt.Errorf("error rotating API key: %v", err)
+	}
+
+	printSecret(out, key.Id, secret)
+
+	return nil
+}
+
+func revokeKey(store apiKeyStore, out io.Writer, args []string) error {
+	id, err := keyId(args)
+	if err != nil {
+		return err
+	}
+
+	err = store.RevokeAPIKey(id)
+	if err != nil {
+		return fmt.Errorf("error revoking API key: %v", err)
+	}
+
+	fmt.Fprintf(out, "API key %v revoked\n", id)
+
+	return nil
+}
+
+func keyId(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expected the ID of the key")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid key ID %q", args[0])
+	}
+
+	return id, nil
+}
+
+func splitList(scopes string) []string {
+	var split []string
+	for _, s := range strings.Split(scopes, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			split = append(split, s)
+		}
+	}
+
+	return split
+}
+
+func printSecret(out io.Writer, id int, secret string) {
+	fmt.Fprintf(out, "API key %v: %s\n", id, secret)
+	fmt.Fprintln(out, "store it now, it can't be shown again")
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/db"
+)
+
+// memoryKeys keeps API keys the way the api_keys table does.
+type memoryKeys struct {
+	keys []db.APIKey
+}
+
+func (m *memoryKeys) CreateAPIKey(key *db.APIKey) error {
+	key.Id = len(m.keys) + 1
+	key.CreatedAt = time.Now()
+	m.keys = append(m.keys, *key)
+	return nil
+}
+
+func (m *memoryKeys) ListAPIKeys() ([]db.APIKey, error) {
+	return m.keys, nil
+}
+
+func (m *memoryKeys) RotateAPIKey(id int, keyHash string) (db.APIKey, error) {
+	err := m.RevokeAPIKey(id)
+	if err != nil {
+		return db.APIKey{}, err
+	}
+
+	old := m.keys[id-1]
+	rotated := db.APIKey{KeyHash: keyHash, Owner: old.Owner, Scopes: old.Scopes, Tier: old.Tier}
+
+	return rotated, m.CreateAPIKey(&rotated)
+}
+
+func (m *memoryKeys) RevokeAPIKey(id int) error {
+	if id < 1 || id > len(m.keys) || !m.keys[id-1].RevokedAt.IsZero() {
+		return fmt.Errorf("no active API key with id %v", id)
+	}
+
+	m.keys[id-1].RevokedAt = time.Now()
+	return nil
+}
+
+func TestKeys(t *testing.T) {
+	store := &memoryKeys{}
+
+	tests := []struct {
+		args   []string
+		code   int
+		output string
+	}{
+		{args: []string{"create"}, code: 1},
+		{args: []string{"create", "-owner", "partner", "-scopes", "flights:read, flights:partner", "-tier", "partner"}, output: "API key 1: nf_"},
+		{args: []string{"list"}, output: "flights:read,flights:partner"},
+		{args: []string{"rotate", "1"}, output: "API key 2: nf_"},
+		{args: []string{"rotate", "1"}, code: 1},
+		{args: []string{"revoke"}, code: 1},
+		{args: []string{"revoke", "one"}, code: 1},
+		{args: []string{"revoke", "2"}, output: "API key 2 revoked"},
+		{args: []string{"unknown"}, code: 2},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		code := runKeys(store, &out, test.args)
+
+		if code != test.code {
+			t.Errorf("%v: expected exit code %v, got %v", test.args, test.code, code)
+		}
+
+		if !strings.Contains(out.String(), test.output) {
+			t.Errorf("%v: expected %q in the output, got %q", test.args, test.output, out.String())
+		}
+	}
+
+	if len(store.keys) != 2 {
+		t.Fatalf("expected the created and the rotated key, got %v", store.keys)
+	}
+
+	rotated := store.keys[1]
+	if rotated.Owner != "partner" || rotated.Tier != "partner" || len(rotated.Scopes) != 2 || rotated.KeyHash == store.keys[0].KeyHash {
+		t.Errorf("expected a new key with the owner, scopes and tier of the first one, got %+v", rotated)
+	}
+
+	for _, key := range store.keys {
+		if key.RevokedAt.IsZero() {
+			t.Errorf("expected key %v to be revoked", key.Id)
+		}
+	}
+}
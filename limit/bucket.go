@@ -0,0 +1,38 @@
+package limit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket holding up to burst tokens and refilled at rate tokens per second.
+type bucket struct {
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+func newBucket(rate float64, burst int) *bucket {
+	return &bucket{rate: rate, burst: float64(burst), tokens: float64(burst), updated: time.Now()}
+}
+
+// take removes a token, or returns how long until one is available.
+func (b *bucket) take(now time.Time) (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens += now.Sub(b.updated).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
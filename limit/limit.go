@@ -0,0 +1,143 @@
+package limit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/nearbyflights/nearbyflights/authentication"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// a client over its stream limit is told to retry after this long
+const streamRetryDelay = time.Second * 30
+
+type Options struct {
+	// MaxStreams is the number of concurrent streams a client may open, zero means no limit.
+	MaxStreams int
+	// MessageRate is the number of messages per second a stream may send, zero means no limit.
+	MessageRate float64
+	// MessageBurst is the number of messages a stream may send at once before MessageRate applies.
+	MessageBurst int
+}
+
+// Limiter caps the streams of each authenticated client and rate limits the messages they send.
+type Limiter struct {
+	// MaxStreamsOf returns the stream limit of the principal's tier, the lower of it and MaxStreams
+	// applies. Zero, like a nil MaxStreamsOf, leaves MaxStreams alone. Set it before the limiter is used.
+	MaxStreamsOf func(principal authentication.Principal) int
+
+	options Options
+	mutex   sync.Mutex
+	streams map[string]int
+}
+
+func New(options Options) *Limiter {
+	l := &Limiter{streams: make(map[string]int)}
+	l.SetOptions(options)
+
+	return l
+}
+
+// SetOptions changes the limits of new streams, open streams keep their message rate and only
+// count against the new stream limit.
+func (l *Limiter) SetOptions(options Options) {
+	if options.MessageBurst < 1 {
+		options.MessageBurst = 1
+	}
+
+	l.mutex.Lock()
+	l.options = options
+	l.mutex.Unlock()
+}
+
+// StreamInterceptor must run after authentication, streams are counted by their principal.
+func (l *Limiter) StreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	principal, ok := authentication.FromContext(stream.Context())
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing principal")
+	}
+
+	options, ok := l.acquire(principal)
+	if !ok {
+		log.Warnf("[%s] refused stream over the limit of %v", principal.ID, options.MaxStreams)
+		return exhausted(streamRetryDelay, "at most %v concurrent stream(s) allowed for this client", options.MaxStreams)
+	}
+
+	defer l.release(principal.ID)
+
+	if options.MessageRate > 0 {
+		stream = &limitedStream{ServerStream: stream, bucket: newBucket(options.MessageRate, options.MessageBurst)}
+	}
+
+	return handler(srv, stream)
+}
+
+// acquire counts a new stream of the client, it also returns the options the stream is limited by.
+func (l *Limiter) acquire(principal authentication.Principal) (Options, bool) {
+	var tierStreams int
+	if l.MaxStreamsOf != nil {
+		tierStreams = l.MaxStreamsOf(principal)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	options := l.options
+	if tierStreams > 0 && (options.MaxStreams == 0 || tierStreams < options.MaxStreams) {
+		options.MaxStreams = tierStreams
+	}
+
+	if options.MaxStreams > 0 && l.streams[principal.ID] >= options.MaxStreams {
+		return options, false
+	}
+
+	l.streams[principal.ID]++
+
+	return options, true
+}
+
+func (l *Limiter) release(clientId string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.streams[clientId]--
+	if l.streams[clientId] <= 0 {
+		delete(l.streams, clientId)
+	}
+}
+
+// limitedStream fails the stream when the client sends messages faster than its bucket allows.
+type limitedStream struct {
+	grpc.ServerStream
+	bucket *bucket
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	ok, wait := s.bucket.take(time.Now())
+	if !ok {
+		return exhausted(wait, "messages sent faster than %v per second", s.bucket.rate)
+	}
+
+	return nil
+}
+
+func exhausted(retryAfter time.Duration, format string, a ...interface{}) error {
+	st := status.Newf(codes.ResourceExhausted, format, a...)
+
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(retryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -0,0 +1,163 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/authentication"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stream) RecvMsg(interface{}) error {
+	return nil
+}
+
+func newStream(clientId string) *stream {
+	return &stream{ctx: authentication.NewContext(context.Background(), authentication.Principal{ID: clientId})}
+}
+
+func TestStreamInterceptor_MaxStreams(t *testing.T) {
+	limiter := New(Options{MaxStreams: 1})
+
+	opened := make(chan struct{})
+	closed := make(chan struct{})
+	go limiter.StreamInterceptor(nil, newStream("client"), nil, func(interface{}, grpc.ServerStream) error {
+		close(opened)
+		<-closed
+		return nil
+	})
+	<-opened
+
+	err := limiter.StreamInterceptor(nil, newStream("client"), nil, func(interface{}, grpc.ServerStream) error { return nil })
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("second stream should be refused, got %v", err)
+	}
+
+	if len(st.Details()) != 1 {
+		t.Fatalf("refusal should carry retry details: %v", st.Details())
+	}
+
+	if _, ok := st.Details()[0].(*errdetails.RetryInfo); !ok {
+		t.Errorf("unexpected details: %v", st.Details())
+	}
+
+	err = limiter.StreamInterceptor(nil, newStream("another client"), nil, func(interface{}, grpc.ServerStream) error { return nil })
+	if err != nil {
+		t.Errorf("streams of another client should not be limited: %v", err)
+	}
+
+	close(closed)
+}
+
+func TestSetOptions(t *testing.T) {
+	limiter := New(Options{MaxStreams: 1})
+
+	opened := make(chan struct{})
+	closed := make(chan struct{})
+	defer close(closed)
+
+	go limiter.StreamInterceptor(nil, newStream("client"), nil, func(interface{}, grpc.ServerStream) error {
+		close(opened)
+		<-closed
+		return nil
+	})
+	<-opened
+
+	limiter.SetOptions(Options{MaxStreams: 2})
+
+	err := limiter.StreamInterceptor(nil, newStream("client"), nil, func(interface{}, grpc.ServerStream) error { return nil })
+	if err != nil {
+		t.Errorf("second stream should be allowed by the new limit: %v", err)
+	}
+
+	limiter.SetOptions(Options{MaxStreams: 1})
+
+	err = limiter.StreamInterceptor(nil, newStream("client"), nil, func(interface{}, grpc.ServerStream) error { return nil })
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("open streams should count against the lowered limit, got %v", err)
+	}
+}
+
+func TestStreamInterceptor_TierMaxStreams(t *testing.T) {
+	limiter := New(Options{MaxStreams: 2})
+	limiter.MaxStreamsOf = func(principal authentication.Principal) int {
+		if principal.ID == "free" {
+			return 1
+		}
+
+		return 5
+	}
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	for _, clientId := range []string{"free", "partner", "partner"} {
+		opened := make(chan struct{})
+		go limiter.StreamInterceptor(nil, newStream(clientId), nil, func(interface{}, grpc.ServerStream) error {
+			close(opened)
+			<-closed
+			return nil
+		})
+		<-opened
+	}
+
+	// the free tier allows fewer streams than the limiter, the partner tier more
+	for _, clientId := range []string{"free", "partner"} {
+		err := limiter.StreamInterceptor(nil, newStream(clientId), nil, func(interface{}, grpc.ServerStream) error { return nil })
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("%s stream should be refused by the lower limit, got %v", clientId, err)
+		}
+	}
+}
+
+func TestStreamInterceptor_MessageRate(t *testing.T) {
+	limiter := New(Options{MessageRate: 1, MessageBurst: 2})
+
+	err := limiter.StreamInterceptor(nil, newStream("client"), nil, func(_ interface{}, s grpc.ServerStream) error {
+		for i := 0; i < 3; i++ {
+			err := s.RecvMsg(nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("third message in a burst of two should be refused, got %v", err)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := newBucket(2, 1)
+	b.updated = now
+
+	if ok, _ := b.take(now); !ok {
+		t.Error("first token should be available")
+	}
+
+	ok, wait := b.take(now)
+	if ok || wait != time.Millisecond*500 {
+		t.Errorf("empty bucket should refill in 500ms, got %v %v", ok, wait)
+	}
+
+	if ok, _ := b.take(now.Add(time.Millisecond * 500)); !ok {
+		t.Error("token should be available after refilling")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// clientCertificate writes a self-signed client certificate, which is also the CA trusting it.
+func clientCertificate(t *testing.T, dir string) (string, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "my-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "client.crt")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveHealth serves the health service on the listeners of the configuration.
+func serveHealth(t *testing.T, c Configuration) []net.Listener {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cert, err := newTLSCredentials(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opts []grpc.ServerOption
+	if cert != nil {
+		opts = append(opts, grpc.Creds(cert))
+	}
+
+	listeners, err := newListeners(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grpcServer := grpc.NewServer(opts...)
+	grpc_health_v1.RegisterHealthServer(grpcServer, health.NewServer())
+	for _, l := range listeners {
+		go grpcServer.Serve(l)
+	}
+	t.Cleanup(grpcServer.Stop)
+
+	return listeners
+}
+
+// callHealth calls the health service, the error tells whether the connection was accepted.
+func callHealth(target string, option grpc.DialOption) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, target, option, grpc.WithBlock(), grpc.FailOnNonTempDialError(true))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	return err
+}
+
+func TestNewTLSCredentials(t *testing.T) {
+	dir := t.TempDir()
+	certificatePath := filepath.Join(dir, "server.crt")
+	keyPath := filepath.Join(dir, "server.key")
+
+	certificatePEM, keyPEM, err := selfSigned([]string{"localhost", "127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePEM(certificatePath, certificatePEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePEM(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	caPath, clientCert := clientCertificate(t, dir)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certificatePEM)
+	withoutCertificate := grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: roots}))
+	withCertificate := grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}}))
+	plaintext := grpc.WithInsecure()
+
+	tests := []struct {
+		name   string
+		mode   string
+		ca     string
+		option grpc.DialOption
+		ok     bool
+	}{
+		{"off in plaintext", "off", "", plaintext, true},
+		{"off over TLS", "off", "", withoutCertificate, false},
+		{"tls", "tls", "", withoutCertificate, true},
+		{"tls in plaintext", "tls", "", plaintext, false},
+		{"tls with an optional client certificate", "tls", caPath, withCertificate, true},
+		{"tls without the optional client certificate", "tls", caPath, withoutCertificate, true},
+		{"mtls", "mtls", caPath, withCertificate, true},
+		{"mtls without client certificate", "mtls", caPath, withoutCertificate, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listeners := serveHealth(t, Configuration{
+				ListenAddress:         "127.0.0.1:0",
+				TlsMode:               test.mode,
+				TlsCertificatePath:    certificatePath,
+				TlsCertificateKeyPath: keyPath,
+				TlsClientCAPath:       test.ca,
+			})
+
+			err := callHealth(listeners[0].Addr().String(), test.option)
+			if test.ok && err != nil {
+				t.Errorf("expected the connection to be accepted: %v", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("expected the connection to be refused")
+			}
+		})
+	}
+
+	if _, err := newTLSCredentials(context.Background(), Configuration{TlsMode: "mtls", TlsCertificatePath: certificatePath, TlsCertificateKeyPath: keyPath}); err == nil {
+		t.Error("expected mtls to need a client CA")
+	}
+	if _, err := newTLSCredentials(context.Background(), Configuration{TlsMode: "ssl"}); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestNewListeners(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "nearbyflights.sock")
+
+	// a server that didn't stop cleanly leaves its socket behind
+	if err := ioutil.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	listeners := serveHealth(t, Configuration{ListenAddress: "127.0.0.1:0", UnixSocket: socket, TlsMode: "off"})
+	if len(listeners) != 2 {
+		t.Fatalf("expected a TCP and a Unix listener, got %v", listeners)
+	}
+
+	if err := callHealth(listeners[0].Addr().String(), grpc.WithInsecure()); err != nil {
+		t.Errorf("expected the TCP listener to serve: %v", err)
+	}
+	if err := callHealth("unix://"+socket, grpc.WithInsecure()); err != nil {
+		t.Errorf("expected the Unix socket to serve: %v", err)
+	}
+
+	for _, l := range listeners {
+		l.Close()
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed once closed: %v", err)
+	}
+
+	if _, err := newListeners(Configuration{}); err == nil {
+		t.Error("expected an error with nothing to listen on")
+	}
+
+	_, err := newListeners(Configuration{ListenAddress: "127.0.0.1:0", UnixSocket: filepath.Join(socket, "missing", "nearbyflights.sock")})
+	if err == nil {
+		t.Error("expected an error for a socket in a missing folder")
+	}
+}
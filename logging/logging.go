@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/peer"
+)
+
+type Options struct {
+	// Level is the lowest level logged: trace, debug, info, warn, error, fatal or panic.
+	Level string
+	// Format is text or json.
+	Format string
+	// SampleInterval is the shortest time between two high-volume messages of the same kind
+	// in a stream, zero logs all of them.
+	SampleInterval time.Duration
+}
+
+var (
+	sampleMutex    sync.RWMutex
+	sampleInterval time.Duration
+)
+
+// Setup configures the standard logrus logger, which every package logs to. It can be called
+// again to change the options while the server runs.
+func Setup(options Options) error {
+	level, err := log.ParseLevel(options.Level)
+	if err != nil {
+		return err
+	}
+
+	switch options.Format {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", options.Format)
+	}
+
+	log.SetOutput(os.Stdout)
+	log.SetLevel(level)
+
+	sampleMutex.Lock()
+	sampleInterval = options.SampleInterval
+	sampleMutex.Unlock()
+
+	return nil
+}
+
+type contextKey struct{}
+
+// streamLogger is the logger of a stream, it remembers when each sampled message was last logged.
+type streamLogger struct {
+	entry   *log.Entry
+	mutex   sync.Mutex
+	sampled map[string]time.Time
+}
+
+// NewContext returns a copy of the context carrying a logger with the fields of the stream:
+// its ID, the client ID and the peer address.
+func NewContext(ctx context.Context, streamId uint64, clientId string) context.Context {
+	fields := log.Fields{"stream_id": streamId, "client_id": clientId}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields["peer"] = p.Addr.String()
+	}
+
+	return context.WithValue(ctx, contextKey{}, &streamLogger{entry: log.WithFields(fields), sampled: make(map[string]time.Time)})
+}
+
+// FromContext returns the logger of the stream owning the context, or the standard logger.
+func FromContext(ctx context.Context) *log.Entry {
+	if l, ok := ctx.Value(contextKey{}).(*streamLogger); ok {
+		return l.entry
+	}
+
+	return log.NewEntry(log.StandardLogger())
+}
+
+// Sample tells whether a high-volume message of the given kind should be logged for the stream
+// owning the context, at most once per sample interval.
+func Sample(ctx context.Context, kind string) bool {
+	sampleMutex.RLock()
+	interval := sampleInterval
+	sampleMutex.RUnlock()
+
+	l, ok := ctx.Value(contextKey{}).(*streamLogger)
+	if !ok || interval <= 0 {
+		return true
+	}
+
+	now := time.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.sampled[kind]) < interval {
+		return false
+	}
+
+	l.sampled[kind] = now
+
+	return true
+}
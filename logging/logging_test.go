@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetup(t *testing.T) {
+	err := Setup(Options{Level: "debug", Format: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Setup(Options{Level: "verbose", Format: "json"}); err == nil {
+		t.Error("unknown levels should be refused")
+	}
+
+	if err := Setup(Options{Level: "info", Format: "xml"}); err == nil {
+		t.Error("unknown formats should be refused")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	ctx := NewContext(context.Background(), 7, "client")
+
+	entry := FromContext(ctx)
+	if entry.Data["stream_id"] != uint64(7) || entry.Data["client_id"] != "client" {
+		t.Errorf("stream logger should carry the stream fields: %v", entry.Data)
+	}
+
+	if len(FromContext(context.Background()).Data) != 0 {
+		t.Error("contexts without a stream should get the standard logger")
+	}
+}
+
+func TestSample(t *testing.T) {
+	sampleInterval = time.Hour
+	defer func() { sampleInterval = 0 }()
+
+	ctx := NewContext(context.Background(), 1, "client")
+
+	if !Sample(ctx, "search") {
+		t.Error("first message should be logged")
+	}
+
+	if Sample(ctx, "search") {
+		t.Error("second message in the interval should be dropped")
+	}
+
+	if !Sample(ctx, "flights") {
+		t.Error("messages of another kind should be sampled apart")
+	}
+
+	if !Sample(NewContext(context.Background(), 2, "client"), "search") {
+		t.Error("each stream should be sampled apart")
+	}
+}
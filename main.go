@@ -2,98 +2,689 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/nearbyflights/nearbyflights/audit"
 	"github.com/nearbyflights/nearbyflights/authentication"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
-	"github.com/kelseyhightower/envconfig"
 	"github.com/nearbyflights/nearbyflights/db"
+	"github.com/nearbyflights/nearbyflights/embedded"
 	grpcService "github.com/nearbyflights/nearbyflights/grpc"
+	"github.com/nearbyflights/nearbyflights/healthcheck"
+	"github.com/nearbyflights/nearbyflights/hub"
+	"github.com/nearbyflights/nearbyflights/limit"
+	"github.com/nearbyflights/nearbyflights/logging"
+	"github.com/nearbyflights/nearbyflights/policy"
 	service "github.com/nearbyflights/nearbyflights/proto"
+	"github.com/nearbyflights/nearbyflights/schedule"
+	"github.com/nearbyflights/nearbyflights/snapshot"
+	"github.com/nearbyflights/nearbyflights/tlsreload"
+	"github.com/nearbyflights/nearbyflights/tracing"
+	"github.com/nearbyflights/nearbyflights/watchdog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 )
 
+// Configuration is read from the environment and the optional CONFIG_FILE, see loadConfiguration.
+// Fields tagged reload are applied again on SIGHUP, secret ones are masked when printed.
 type Configuration struct {
-	PostgresUrl           string `required:"true" envconfig:"POSTGRES_URL" default:"localhost:5432"`
-	User                  string `required:"true" envconfig:"POSTGRES_USER" default:"admin"`
-	Password              string `required:"true" envconfig:"POSTGRES_PASSWORD" default:"secret"`
-	DatabaseName          string `required:"true" envconfig:"POSTGRES_DB" default:"flights"`
-	IntrospectionUrl      string `required:"true" envconfig:"INTROSPECTION_URL" default:"http://localhost:4445/oauth2/introspect"`
-	TlsCertificatePath    string `required:"true" envconfig:"TLS_CERTIFICATE_PATH" default:"./proto/x509/server.crt"`
-	TlsCertificateKeyPath string `required:"true" envconfig:"TLS_CERTIFICATE_KEY_PATH" default:"./proto/x509/server.key"`
-}
-
-func init() {
-	log.SetOutput(os.Stdout)
-	log.SetFormatter(&log.TextFormatter{ForceColors: true})
+	ConfigFile                    string        `envconfig:"CONFIG_FILE"`
+	LogLevel                      string        `envconfig:"LOG_LEVEL" default:"info" reload:"true"`
+	LogFormat                     string        `envconfig:"LOG_FORMAT" default:"text" reload:"true"`
+	LogSampleInterval             time.Duration `envconfig:"LOG_SAMPLE_INTERVAL" default:"10s" reload:"true"`
+	StorageBackend                string        `envconfig:"STORAGE_BACKEND" default:"postgres"`
+	EmbeddedPath                  string        `envconfig:"EMBEDDED_PATH" default:"./flights.db"`
+	EmbeddedCellSize              float64       `envconfig:"EMBEDDED_CELL_SIZE" default:"1"`
+	EmbeddedImportPath            string        `envconfig:"EMBEDDED_IMPORT_PATH" default:"./flights.import"`
+	EmbeddedImportInterval        time.Duration `envconfig:"EMBEDDED_IMPORT_INTERVAL" default:"5s"`
+	SnapshotRefreshInterval       time.Duration `envconfig:"SNAPSHOT_REFRESH_INTERVAL" default:"5s"`
+	SnapshotCellSize              float64       `envconfig:"SNAPSHOT_CELL_SIZE" default:"1"`
+	TileRefreshInterval           time.Duration `envconfig:"TILE_REFRESH_INTERVAL" default:"1s"`
+	TileSize                      float64       `envconfig:"TILE_SIZE" default:"0.5"`
+	SchedulerWorkers              int           `envconfig:"SCHEDULER_WORKERS" default:"64"`
+	DedupeWindow                  time.Duration `envconfig:"DEDUPE_WINDOW" default:"1h" reload:"true"`
+	PostgresUrl                   string        `required:"true" envconfig:"POSTGRES_URL" default:"localhost:5432"`
+	PostgresReplicaUrls           []string      `envconfig:"POSTGRES_REPLICA_URLS"`
+	PostgresHealthCheck           time.Duration `envconfig:"POSTGRES_HEALTH_CHECK_INTERVAL" default:"10s"`
+	PostgresUpdatedAtColumn       string        `envconfig:"POSTGRES_UPDATED_AT_COLUMN"`
+	User                          string        `required:"true" envconfig:"POSTGRES_USER" default:"admin"`
+	Password                      string        `envconfig:"POSTGRES_PASSWORD" secret:"true"`
+	DatabaseName                  string        `required:"true" envconfig:"POSTGRES_DB" default:"flights"`
+	LimitMaxStreams               int           `envconfig:"LIMIT_MAX_STREAMS" default:"10" reload:"true"`
+	LimitMessageRate              float64       `envconfig:"LIMIT_MESSAGE_RATE" default:"1" reload:"true"`
+	LimitMessageBurst             int           `envconfig:"LIMIT_MESSAGE_BURST" default:"5" reload:"true"`
+	HTTPAddress                   string        `envconfig:"HTTP_ADDRESS" default:":9090"`
+	HealthCheckInterval           time.Duration `envconfig:"HEALTH_CHECK_INTERVAL" default:"10s"`
+	HealthCheckTimeout            time.Duration `envconfig:"HEALTH_CHECK_TIMEOUT" default:"5s"`
+	HealthCriticalChecks          []string      `envconfig:"HEALTH_CRITICAL_CHECKS" default:"database,postgis"`
+	FreshnessMaxAge               time.Duration `envconfig:"FRESHNESS_MAX_AGE" default:"5m"`
+	FreshnessCheckInterval        time.Duration `envconfig:"FRESHNESS_CHECK_INTERVAL" default:"10s"`
+	TracingExporter               string        `envconfig:"TRACING_EXPORTER" default:"none"`
+	TracingEndpoint               string        `envconfig:"TRACING_ENDPOINT" default:"localhost:4317"`
+	TracingInsecure               bool          `envconfig:"TRACING_INSECURE" default:"false"`
+	TracingSampleRatio            float64       `envconfig:"TRACING_SAMPLE_RATIO" default:"1"`
+	AuditLog                      string        `envconfig:"AUDIT_LOG"`
+	PolicyFile                    string        `envconfig:"POLICY_FILE"`
+	Reauthentication              time.Duration `envconfig:"REAUTHENTICATION_INTERVAL" default:"0s"`
+	AdminScope                    string        `envconfig:"ADMIN_SCOPE" default:"nearbyflights:admin"`
+	ShutdownDelay                 time.Duration `envconfig:"SHUTDOWN_DELAY" default:"0s"`
+	ShutdownTimeout               time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"30s"`
+	Authenticators                []string      `envconfig:"AUTHENTICATORS" default:"token"`
+	APIKeysSource                 string        `envconfig:"API_KEYS_SOURCE" default:"file"`
+	APIKeysFile                   string        `envconfig:"API_KEYS_FILE" default:"./api_keys.json"`
+	APIKeysReloadInterval         time.Duration `envconfig:"API_KEYS_RELOAD_INTERVAL" default:"1m"`
+	CertificateScopes             []string      `envconfig:"MTLS_SCOPES"`
+	CertificateTier               string        `envconfig:"MTLS_TIER"`
+	AuthMode                      string        `envconfig:"AUTH_MODE" default:"introspection"`
+	JWKSUrl                       string        `envconfig:"JWKS_URL" default:"http://localhost:4444/.well-known/jwks.json"`
+	JWKSRefreshInterval           time.Duration `envconfig:"JWKS_REFRESH_INTERVAL" default:"1h"`
+	JWTIssuer                     string        `envconfig:"JWT_ISSUER"`
+	JWTAudience                   string        `envconfig:"JWT_AUDIENCE"`
+	JWTLeeway                     time.Duration `envconfig:"JWT_LEEWAY" default:"1m"`
+	IntrospectionUrl              string        `required:"true" envconfig:"INTROSPECTION_URL" default:"http://localhost:4445/oauth2/introspect"`
+	IntrospectionTimeout          time.Duration `envconfig:"INTROSPECTION_TIMEOUT" default:"5s"`
+	IntrospectionCacheTTL         time.Duration `envconfig:"INTROSPECTION_CACHE_TTL" default:"5m"`
+	IntrospectionNegativeCacheTTL time.Duration `envconfig:"INTROSPECTION_NEGATIVE_CACHE_TTL" default:"10s"`
+	IntrospectionAuth             string        `envconfig:"INTROSPECTION_AUTH" default:"none"`
+	IntrospectionClientId         string        `envconfig:"INTROSPECTION_CLIENT_ID"`
+	IntrospectionClientSecret     string        `envconfig:"INTROSPECTION_CLIENT_SECRET" secret:"true"`
+	IntrospectionBearerToken      string        `envconfig:"INTROSPECTION_BEARER_TOKEN" secret:"true"`
+	IntrospectionTokenUrl         string        `envconfig:"INTROSPECTION_TOKEN_URL" default:"http://localhost:4444/oauth2/token"`
+	IntrospectionScopes           []string      `envconfig:"INTROSPECTION_SCOPES"`
+	IntrospectionTokenLifetime    time.Duration `envconfig:"INTROSPECTION_TOKEN_LIFETIME" default:"5m"`
+	IntrospectionCAPath           string        `envconfig:"INTROSPECTION_CA_PATH"`
+	IntrospectionRetries          int           `envconfig:"INTROSPECTION_RETRIES" default:"2"`
+	IntrospectionRetryBackoff     time.Duration `envconfig:"INTROSPECTION_RETRY_BACKOFF" default:"200ms"`
+	ListenAddress                 string        `envconfig:"LISTEN_ADDRESS" default:":8080"`
+	UnixSocket                    string        `envconfig:"UNIX_SOCKET"`
+	TlsMode                       string        `envconfig:"TLS_MODE" default:"tls"`
+	TlsCertificatePath            string        `required:"true" envconfig:"TLS_CERTIFICATE_PATH" default:"./proto/x509/server.crt"`
+	TlsClientCAPath               string        `envconfig:"TLS_CLIENT_CA_PATH"`
+	TlsReloadInterval             time.Duration `envconfig:"TLS_RELOAD_INTERVAL" default:"1m"`
+	TlsCertificateKeyPath         string        `required:"true" envconfig:"TLS_CERTIFICATE_KEY_PATH" default:"./proto/x509/server.key"`
 }
 
 func main() {
-	var c Configuration
-	err := envconfig.Process("", &c)
+	c, err := readConfiguration()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	if code, ok := runCommand(c, os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
+	err = validate(c)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	err = logging.Setup(logging.Options{Level: c.LogLevel, Format: c.LogFormat, SampleInterval: c.LogSampleInterval})
+	if err != nil {
+		log.Fatalf("error setting up logging: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Options{
+		Exporter:    c.TracingExporter,
+		Endpoint:    c.TracingEndpoint,
+		Insecure:    c.TracingInsecure,
+		SampleRatio: c.TracingSampleRatio,
+		ServiceName: "nearbyflights",
+	})
+	if err != nil {
+		log.Fatalf("error setting up tracing: %v", err)
+	}
+
+	if c.AuditLog != "" {
+		sink, err := audit.Open(c.AuditLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		audit.SetSink(sink)
+	}
+
+	database, err := newStore(ctx, c)
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Fatalf("error opening the %s storage backend: %v", c.StorageBackend, err)
+	}
+
+	// the health checks query PostgreSQL directly, not the snapshot or tiles in front of it
+	client, _ := database.(*db.Client)
+
+	if client != nil && c.PostgresUpdatedAtColumn != "" {
+		exists, err := client.HasColumn(ctx, "flights", c.PostgresUpdatedAtColumn)
+		if err != nil {
+			log.Warnf("error checking the %s column of the flights table: %v", c.PostgresUpdatedAtColumn, err)
+		} else if !exists {
+			log.Fatalf("the flights table has no %s column, fix POSTGRES_UPDATED_AT_COLUMN or unset it to turn the freshness watchdog off", c.PostgresUpdatedAtColumn)
+		}
 	}
 
-	database := db.ClientOptions{
-		Address:  c.PostgresUrl,
-		User:     c.User,
-		Password: c.Password,
-		Database: c.DatabaseName,
+	// the embedded store already lives in memory, only PostgreSQL benefits from a snapshot or shared tiles
+	if c.StorageBackend == "postgres" {
+		if c.SnapshotRefreshInterval > 0 {
+			flights := snapshot.New(database, c.SnapshotCellSize)
+
+			err = flights.Refresh()
+			if err != nil {
+				log.Errorf("error loading the first snapshot, searching PostgreSQL until it loads: %v", err)
+			}
+
+			go flights.Run(ctx, c.SnapshotRefreshInterval)
+			database = flights
+
+			log.Infof("serving searches from a snapshot refreshed every %v", c.SnapshotRefreshInterval)
+		} else if c.TileRefreshInterval > 0 {
+			database = hub.New(database, c.TileSize, c.TileRefreshInterval)
+
+			log.Infof("serving searches from shared tiles of %v degrees refreshed every %v", c.TileSize, c.TileRefreshInterval)
+		}
 	}
 
-	cert, err := credentials.NewServerTLSFromFile(c.TlsCertificatePath, c.TlsCertificateKeyPath)
+	// API keys in PostgreSQL are read with the flights client, the embedded store needs its own
+	keysClient := client
+	if keysClient == nil && c.APIKeysSource == "database" {
+		keysClient = newAPIKeysClient(c)
+	}
+
+	authenticator, err := newAuthenticator(ctx, c, keysClient)
+	if err != nil {
+		log.Fatalf("error setting up authentication: %v", err)
+	}
+
+	cert, err := newTLSCredentials(ctx, c)
 	if err != nil {
 		log.Fatalf("error loading TLS certificate %v", err)
 	}
 
+	tiers := policy.Unlimited
+	if c.PolicyFile != "" {
+		tiers, err = policy.Load(c.PolicyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	limiter := limit.New(limitOptions(c))
+	limiter.MaxStreamsOf = func(principal authentication.Principal) int {
+		tier, err := tiers.TierOf(principal)
+		if err != nil {
+			return 0
+		}
+
+		return tier.MaxStreams
+	}
+
 	opts := []grpc.ServerOption{
-		// Intercept request to check the token.
-		grpc.StreamInterceptor(authentication.NewAuthInterceptor(c.IntrospectionUrl)),
-		// Enable TLS for all incoming connections.
-		grpc.Creds(cert),
+		// Trace the stream, check the credentials and then limit streams and messages per client,
+		// except for health checks and watches, which orchestrators send without credentials.
+		grpc.ChainStreamInterceptor(
+			otelgrpc.StreamServerInterceptor(),
+			unlessHealth(authentication.NewAuthInterceptor(authenticator)),
+			unlessHealth(limiter.StreamInterceptor),
+		),
+		// The admin calls are unary and need the same credentials, health checks don't.
+		grpc.ChainUnaryInterceptor(
+			otelgrpc.UnaryServerInterceptor(),
+			unlessHealthUnary(authentication.NewUnaryAuthInterceptor(authenticator)),
+		),
+	}
+
+	// Enable TLS for all incoming connections, unless it's turned off for local development.
+	if cert != nil {
+		opts = append(opts, grpc.Creds(cert))
+	} else {
+		log.Warn("TLS is off, credentials travel in plaintext")
 	}
 
 	grpcServer := grpc.NewServer(opts...)
-	listener, err := net.Listen("tcp", ":8080")
+	listeners, err := newListeners(c)
 	if err != nil {
 		log.Fatalf("error creating the server %v", err)
 	}
 
 	healthServer := health.NewServer()
-	grpc_health_v1.RegisterHealthServer(grpcServer, healthServer)
-	healthServer.SetServingStatus("nearbyflights", grpc_health_v1.HealthCheckResponse_SERVING)
 
-	log.Info("starting server at port :8080")
+	// PostgreSQL only knows when flights were written through POSTGRES_UPDATED_AT_COLUMN
+	var freshness *watchdog.Watchdog
+	if store, ok := database.(db.Freshness); ok && c.FreshnessMaxAge > 0 && (client == nil || c.PostgresUpdatedAtColumn != "") {
+		freshness = watchdog.New(store, c.FreshnessMaxAge)
+		go freshness.Run(ctx, c.FreshnessCheckInterval)
+	}
+
+	checker := healthcheck.New("nearbyflights", healthServer, c.HealthCheckInterval, c.HealthCheckTimeout, newHealthChecks(c, client, freshness, authenticator)...)
+	go checker.Run(ctx)
+
+	grpc_health_v1.RegisterHealthServer(grpcServer, checker.Server())
+
+	for _, l := range listeners {
+		log.Infof("starting server at %v", l.Addr())
+	}
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-	ctx, cancel := context.WithCancel(context.Background())
 	wg := &sync.WaitGroup{}
 
-	server := &grpcService.Server{HealthServer: healthServer, Options: database, Context: ctx, Wg: wg, UnimplementedNearbyFlightsServer: service.UnimplementedNearbyFlightsServer{}}
+	scheduler := schedule.New(database, c.SchedulerWorkers)
+	scheduler.SetDedupeWindow(c.DedupeWindow)
+	go scheduler.Run(ctx)
+
+	server := &grpcService.Server{HealthServer: healthServer, Scheduler: scheduler, Policy: &tiers, Authenticator: authenticator, Reauthentication: c.Reauthentication, Watchdog: freshness, Context: ctx, Wg: wg, UnimplementedNearbyFlightsServer: service.UnimplementedNearbyFlightsServer{}}
 	service.RegisterNearbyFlightsServer(grpcServer, server)
+	service.RegisterAdminServer(grpcServer, &grpcService.Admin{Server: server, Scope: c.AdminScope})
 
-	go func() {
-		signal := <-signals
-		log.Printf("server closed: %v \n", signal)
+	if c.HTTPAddress != "" {
+		go serveHTTP(c.HTTPAddress, checker)
+	}
+
+	go reloadOnHangup(ctx, c, limiter, scheduler)
+
+	served := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			served <- grpcServer.Serve(l)
+		}(l)
+	}
+
+	code := exitDrained
+	select {
+	case err := <-served:
+		log.Errorf("error serving: %v", err)
+		code = exitServeError
 		cancel()
-		healthServer.SetServingStatus("nearbyflights", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	case signal := <-signals:
+		log.Infof("server closed: %v", signal)
+		code = drain(grpcServer, checker, cancel, wg, c.ShutdownDelay, c.ShutdownTimeout)
+	}
+
+	database.Close()
+	if keysClient != client {
+		keysClient.Close()
+	}
+
+	err = shutdownTracing(context.Background())
+	if err != nil {
+		log.Errorf("error flushing traces: %v", err)
+	}
+
+	log.Exit(code)
+}
+
+// runCommand runs the subcommand in args and returns its exit code, ok is false when there is no
+// subcommand and the server should start. Only keys needs the configuration of the server to be valid.
+func runCommand(c Configuration, args []string) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	switch args[0] {
+	case "certificate":
+		return certificate(c, args[1:]), true
+	case "config":
+		code = printConfiguration(c)
+		if err := validate(c); err != nil {
+			fmt.Fprintf(os.Stderr, "the server would refuse this configuration: %v\n", err)
+		}
+		return code, true
+	case "keys":
+		err := validate(c)
+		if err == nil {
+			err = logging.Setup(logging.Options{Level: c.LogLevel, Format: c.LogFormat, SampleInterval: c.LogSampleInterval})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+			return 1, true
+		}
+		return keys(c, args[1:]), true
+	}
+
+	return 0, false
+}
+
+// Exit codes of the server.
+const (
+	exitDrained = iota
+	exitServeError
+	exitDrainTimeout
+)
+
+// drain takes the server out of the load balancers, waits delay for them to notice, tells every
+// open stream to reconnect and waits up to timeout for the streams to finish before closing the
+// connections that are left.
+func drain(grpcServer *grpc.Server, checker *healthcheck.Checker, cancel context.CancelFunc, wg *sync.WaitGroup, delay time.Duration, timeout time.Duration) int {
+	checker.Shutdown()
+
+	if delay > 0 {
+		log.Infof("waiting %v for the load balancers to stop sending clients", delay)
+		time.Sleep(delay)
+	}
+
+	// health watchers would hold GracefulStop until the orchestrator hangs up
+	checker.Close()
+
+	// streams send the draining notice and finish once the server context is cancelled
+	cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
 		wg.Wait()
-		log.Println("all streams finished, shutting down")
-		log.Exit(0)
+		close(stopped)
 	}()
 
-	err = grpcServer.Serve(listener)
+	select {
+	case <-stopped:
+		log.Info("all streams finished, shutting down")
+		return exitDrained
+	case <-time.After(timeout):
+		log.Warnf("streams still open after %v, closing them", timeout)
+		grpcServer.Stop()
+		return exitDrainTimeout
+	}
+}
+
+// healthService is exempt from credentials and limits, for the Check and Watch calls of orchestrators.
+const healthService = "/grpc.health.v1.Health/"
+
+// unlessHealth skips the stream interceptor for the health service.
+func unlessHealth(interceptor grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthService) {
+			return handler(srv, stream)
+		}
+
+		return interceptor(srv, stream, info, handler)
+	}
+}
+
+// unlessHealthUnary skips the unary interceptor for the health service.
+func unlessHealthUnary(interceptor grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthService) {
+			return handler(ctx, req)
+		}
+
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+// serveHTTP exposes the Prometheus metrics at /metrics and the health of the server at /livez and /readyz.
+func serveHTTP(address string, checker *healthcheck.Checker) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/livez", checker.Livez)
+	mux.HandleFunc("/readyz", checker.Readyz)
+
+	log.Infof("serving metrics and health checks at %s", address)
+
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		log.Errorf("error serving HTTP: %v", err)
+	}
+}
+
+// newHealthChecks probes PostgreSQL and PostGIS, the introspection endpoint and the freshness of
+// the flights, whichever apply to the configuration.
+func newHealthChecks(c Configuration, client *db.Client, freshness *watchdog.Watchdog, authenticator authentication.Authenticator) []healthcheck.Check {
+	critical := make(map[string]bool)
+	for _, name := range c.HealthCriticalChecks {
+		critical[name] = true
+	}
+
+	var checks []healthcheck.Check
+
+	if client != nil {
+		checks = append(checks,
+			healthcheck.Check{Name: "database", Probe: client.Ping},
+			healthcheck.Check{Name: "postgis", Probe: func(ctx context.Context) error {
+				_, err := client.PostGISVersion(ctx)
+				return err
+			}},
+		)
+	}
+
+	if chain, ok := authenticator.(authentication.Chain); ok {
+		for _, a := range chain {
+			token, ok := a.(authentication.TokenAuthenticator)
+			if !ok {
+				continue
+			}
+
+			if introspector, ok := token.Introspector.(*authentication.Introspector); ok {
+				checks = append(checks, healthcheck.Check{Name: "introspection", Probe: introspector.Ping})
+			}
+		}
+	}
+
+	// the check follows the watchdog, so the health goes degraded when streams are told the flights are stale
+	if freshness != nil {
+		checks = append(checks, healthcheck.Check{Name: "freshness", Probe: freshness.Probe})
+	}
+
+	for i := range checks {
+		checks[i].Critical = critical[checks[i].Name]
+	}
+
+	return checks
+}
+
+// newStore opens the flight store of STORAGE_BACKEND. The embedded store imports flights from
+// EMBEDDED_IMPORT_PATH until the context is done.
+func newStore(ctx context.Context, c Configuration) (db.Store, error) {
+	switch c.StorageBackend {
+	case "postgres":
+		return db.NewClient(db.ClientOptions{
+			Address:             c.PostgresUrl,
+			Replicas:            c.PostgresReplicaUrls,
+			User:                c.User,
+			Password:            c.Password,
+			Database:            c.DatabaseName,
+			HealthCheckInterval: c.PostgresHealthCheck,
+			UpdatedAtColumn:     c.PostgresUpdatedAtColumn,
+		}), nil
+	case "embedded":
+		store, err := embedded.Open(embedded.Options{Path: c.EmbeddedPath, CellSize: c.EmbeddedCellSize})
+		if err != nil {
+			return nil, err
+		}
+
+		if c.EmbeddedImportPath != "" {
+			go store.RunImport(ctx, c.EmbeddedImportPath, c.EmbeddedImportInterval)
+		}
+
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", c.StorageBackend)
+	}
+}
+
+// newAuthenticator chains the configured authenticators in order.
+func newAuthenticator(ctx context.Context, c Configuration, keysClient *db.Client) (authentication.Authenticator, error) {
+	var chain authentication.Chain
+
+	for _, name := range c.Authenticators {
+		switch name {
+		case "token":
+			introspector, err := newIntrospector(ctx, c)
+			if err != nil {
+				return nil, fmt.Errorf("error setting up the %s authentication mode: %v", c.AuthMode, err)
+			}
+
+			chain = append(chain, authentication.TokenAuthenticator{Introspector: introspector})
+		case "apikey":
+			source, err := newAPIKeySource(c, keysClient)
+			if err != nil {
+				return nil, err
+			}
+
+			apiKeys, err := authentication.NewAPIKeyAuthenticator(source)
+			if err != nil {
+				return nil, err
+			}
+
+			go apiKeys.Run(ctx, c.APIKeysReloadInterval)
+
+			chain = append(chain, apiKeys)
+		case "mtls":
+			if c.TlsClientCAPath == "" {
+				return nil, errors.New("the mtls authenticator needs TLS_CLIENT_CA_PATH")
+			}
+
+			chain = append(chain, authentication.CertificateAuthenticator{Scopes: c.CertificateScopes, Tier: c.CertificateTier})
+		default:
+			return nil, fmt.Errorf("unknown authenticator %q", name)
+		}
+	}
+
+	return chain, nil
+}
+
+func newAPIKeySource(c Configuration, keysClient *db.Client) (authentication.APIKeySource, error) {
+	switch c.APIKeysSource {
+	case "file":
+		return authentication.APIKeyFile(c.APIKeysFile), nil
+	case "database":
+		return databaseAPIKeys{client: keysClient}, nil
+	default:
+		return nil, fmt.Errorf("unknown API keys source %q", c.APIKeysSource)
+	}
+}
+
+// newListeners listens on LISTEN_ADDRESS and on UNIX_SOCKET, whichever are set.
+func newListeners(c Configuration) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	if c.ListenAddress != "" {
+		l, err := net.Listen("tcp", c.ListenAddress)
+		if err != nil {
+			return nil, err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	if c.UnixSocket != "" {
+		// a socket left behind by a server that didn't stop cleanly makes Listen fail
+		err := os.Remove(c.UnixSocket)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		l, err := net.Listen("unix", c.UnixSocket)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("nothing to listen on, set LISTEN_ADDRESS or UNIX_SOCKET")
+	}
+
+	return listeners, nil
+}
+
+// newTLSCredentials returns the credentials for TLS_MODE, nil when TLS is off. In tls mode clients are
+// asked for a certificate signed by TLS_CLIENT_CA_PATH when it is set, clients without one can still
+// authenticate with a token or an API key. In mtls mode the certificate is required. The files are
+// read again every TLS_RELOAD_INTERVAL.
+func newTLSCredentials(ctx context.Context, c Configuration) (credentials.TransportCredentials, error) {
+	switch c.TlsMode {
+	case "off":
+		return nil, nil
+	case "tls":
+	case "mtls":
+		if c.TlsClientCAPath == "" {
+			return nil, errors.New("TLS_MODE=mtls needs TLS_CLIENT_CA_PATH")
+		}
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q", c.TlsMode)
+	}
+
+	options := tlsreload.Options{
+		CertificatePath: c.TlsCertificatePath,
+		KeyPath:         c.TlsCertificateKeyPath,
+		ClientCAPath:    c.TlsClientCAPath,
+		ClientAuth:      tls.VerifyClientCertIfGiven,
+	}
+	if c.TlsMode == "mtls" {
+		options.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	reloader, err := tlsreload.New(options)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	if c.TlsReloadInterval > 0 {
+		go reloader.Run(ctx, c.TlsReloadInterval)
+	}
+
+	return credentials.NewTLS(reloader.Config()), nil
+}
+
+func newIntrospector(ctx context.Context, c Configuration) (authentication.TokenIntrospector, error) {
+	switch c.AuthMode {
+	case "introspection":
+		return authentication.NewIntrospector(authentication.IntrospectorOptions{
+			Url:              c.IntrospectionUrl,
+			Timeout:          c.IntrospectionTimeout,
+			CacheTTL:         c.IntrospectionCacheTTL,
+			NegativeCacheTTL: c.IntrospectionNegativeCacheTTL,
+			CAFile:           c.IntrospectionCAPath,
+			Auth: authentication.ClientAuth{
+				Method:        c.IntrospectionAuth,
+				ClientId:      c.IntrospectionClientId,
+				ClientSecret:  c.IntrospectionClientSecret,
+				BearerToken:   c.IntrospectionBearerToken,
+				TokenUrl:      c.IntrospectionTokenUrl,
+				Scopes:        c.IntrospectionScopes,
+				TokenLifetime: c.IntrospectionTokenLifetime,
+			},
+			Retries:      c.IntrospectionRetries,
+			RetryBackoff: c.IntrospectionRetryBackoff,
+		})
+	case "jwt":
+		validator := authentication.NewJWTValidator(authentication.JWTOptions{
+			JWKSUrl:         c.JWKSUrl,
+			RefreshInterval: c.JWKSRefreshInterval,
+			Timeout:         c.IntrospectionTimeout,
+			Issuer:          c.JWTIssuer,
+			Audience:        c.JWTAudience,
+			Leeway:          c.JWTLeeway,
+		})
+
+		err := validator.Refresh()
+		if err != nil {
+			return nil, err
+		}
+
+		go validator.Run(ctx)
+
+		return validator, nil
+	default:
+		return nil, fmt.Errorf("unknown authentication mode %q", c.AuthMode)
 	}
 }
@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/authentication"
+)
+
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+// Tier limits what a client may ask for. Zero values mean no limit.
+type Tier struct {
+	Name string `json:"name"`
+	// Scopes grant the tier to principals holding any of them.
+	Scopes []string `json:"scopes"`
+	// MaxRadius is in meters.
+	MaxRadius   float64  `json:"max_radius"`
+	MinInterval Duration `json:"min_interval"`
+	// MaxStreams is enforced by the limiter, together with its own limit.
+	MaxStreams int `json:"max_streams"`
+	// AllowedRPCs are full method names such as /proto.NearbyFlights/Receive, empty allows every RPC.
+	AllowedRPCs []string `json:"allowed_rpcs"`
+}
+
+// Policy maps principals to tiers. Tiers are listed from the least to the most privileged and
+// a principal holding scopes of several tiers gets the most privileged one.
+type Policy struct {
+	Default string `json:"default"`
+	Tiers   []Tier `json:"tiers"`
+}
+
+// Unlimited is used when no policy file is configured, everyone gets a single tier without limits.
+var Unlimited = Policy{Default: "default", Tiers: []Tier{{Name: "default"}}}
+
+func Load(path string) (Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Policy{}, fmt.Errorf("error reading policy file: %v", err)
+	}
+
+	var p Policy
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return Policy{}, fmt.Errorf("error unmarshalling policy file: %v", err)
+	}
+
+	if p.Default != "" {
+		if _, ok := p.tier(p.Default); !ok {
+			return Policy{}, fmt.Errorf("default tier %q is not defined", p.Default)
+		}
+	}
+
+	return p, nil
+}
+
+// TierOf returns the tier set on the principal itself (API keys and certificates) or the most
+// privileged tier granted by its scopes, falling back to the default tier.
+func (p Policy) TierOf(principal authentication.Principal) (Tier, error) {
+	if principal.Tier != "" {
+		tier, ok := p.tier(principal.Tier)
+		if !ok {
+			return Tier{}, fmt.Errorf("unknown tier %q", principal.Tier)
+		}
+
+		return tier, nil
+	}
+
+	for i := len(p.Tiers) - 1; i >= 0; i-- {
+		for _, scope := range p.Tiers[i].Scopes {
+			for _, s := range principal.Scopes {
+				if s == scope {
+					return p.Tiers[i], nil
+				}
+			}
+		}
+	}
+
+	tier, ok := p.tier(p.Default)
+	if !ok {
+		return Tier{}, fmt.Errorf("no tier granted to %s", principal.ID)
+	}
+
+	return tier, nil
+}
+
+func (p Policy) tier(name string) (Tier, bool) {
+	for _, t := range p.Tiers {
+		if t.Name == name {
+			return t, true
+		}
+	}
+
+	return Tier{}, false
+}
+
+func (t Tier) Allows(method string) bool {
+	if len(t.AllowedRPCs) == 0 {
+		return true
+	}
+
+	for _, m := range t.AllowedRPCs {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check returns the reason the options are refused, or an empty string when they are within the tier.
+func (t Tier) Check(radius float64, interval time.Duration) string {
+	if t.MaxRadius > 0 && radius > t.MaxRadius {
+		return fmt.Sprintf("radius of %vm exceeds the %vm allowed by the %s tier", radius, t.MaxRadius, t.Name)
+	}
+
+	if t.MinInterval > 0 && interval < time.Duration(t.MinInterval) {
+		return fmt.Sprintf("interval of %v is below the %v allowed by the %s tier", interval, time.Duration(t.MinInterval), t.Name)
+	}
+
+	return ""
+}
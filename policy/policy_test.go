@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/authentication"
+)
+
+var tiers = Policy{
+	Default: "free",
+	Tiers: []Tier{
+		{Name: "free", MaxRadius: 50000, MinInterval: Duration(time.Second * 10)},
+		{Name: "partner", Scopes: []string{"flights:partner"}, MaxRadius: 200000},
+		{Name: "admin", Scopes: []string{"flights:admin"}},
+	},
+}
+
+func TestTierOf(t *testing.T) {
+	tests := map[string]authentication.Principal{
+		"free":    {ID: "anonymous", Scopes: []string{"openid"}},
+		"partner": {ID: "partner", Scopes: []string{"flights:partner"}},
+		"admin":   {ID: "operator", Scopes: []string{"flights:partner", "flights:admin"}},
+	}
+
+	for expected, principal := range tests {
+		tier, err := tiers.TierOf(principal)
+		if err != nil || tier.Name != expected {
+			t.Errorf("%s should get the %s tier, got %v %v", principal.ID, expected, tier.Name, err)
+		}
+	}
+}
+
+func TestTierOf_PrincipalTier(t *testing.T) {
+	tier, err := tiers.TierOf(authentication.Principal{ID: "api-key", Tier: "partner"})
+	if err != nil || tier.Name != "partner" {
+		t.Errorf("principal tier should be used, got %v %v", tier.Name, err)
+	}
+
+	_, err = tiers.TierOf(authentication.Principal{ID: "api-key", Tier: "unknown"})
+	if err == nil {
+		t.Error("unknown principal tier should be refused")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	free, _ := tiers.TierOf(authentication.Principal{})
+
+	if reason := free.Check(10000, time.Minute); reason != "" {
+		t.Errorf("options within the tier should be allowed: %s", reason)
+	}
+
+	if reason := free.Check(100000, time.Minute); reason == "" {
+		t.Error("radius above the tier maximum should be refused")
+	}
+
+	if reason := free.Check(10000, time.Second); reason == "" {
+		t.Error("interval below the tier minimum should be refused")
+	}
+}
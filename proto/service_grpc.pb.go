@@ -18,6 +18,8 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type NearbyFlightsClient interface {
 	Receive(ctx context.Context, opts ...grpc.CallOption) (NearbyFlights_ReceiveClient, error)
+	// ReceiveUpdates works like Receive and also sends the status of the data between the flights.
+	ReceiveUpdates(ctx context.Context, opts ...grpc.CallOption) (NearbyFlights_ReceiveUpdatesClient, error)
 }
 
 type nearbyFlightsClient struct {
@@ -59,11 +61,44 @@ func (x *nearbyFlightsReceiveClient) Recv() (*Flight, error) {
 	return m, nil
 }
 
+func (c *nearbyFlightsClient) ReceiveUpdates(ctx context.Context, opts ...grpc.CallOption) (NearbyFlights_ReceiveUpdatesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_NearbyFlights_serviceDesc.Streams[1], "/proto.NearbyFlights/ReceiveUpdates", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &nearbyFlightsReceiveUpdatesClient{stream}
+	return x, nil
+}
+
+type NearbyFlights_ReceiveUpdatesClient interface {
+	Send(*Options) error
+	Recv() (*Update, error)
+	grpc.ClientStream
+}
+
+type nearbyFlightsReceiveUpdatesClient struct {
+	grpc.ClientStream
+}
+
+func (x *nearbyFlightsReceiveUpdatesClient) Send(m *Options) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *nearbyFlightsReceiveUpdatesClient) Recv() (*Update, error) {
+	m := new(Update)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // NearbyFlightsServer is the server API for NearbyFlights service.
 // All implementations must embed UnimplementedNearbyFlightsServer
 // for forward compatibility
 type NearbyFlightsServer interface {
 	Receive(NearbyFlights_ReceiveServer) error
+	// ReceiveUpdates works like Receive and also sends the status of the data between the flights.
+	ReceiveUpdates(NearbyFlights_ReceiveUpdatesServer) error
 	mustEmbedUnimplementedNearbyFlightsServer()
 }
 
@@ -74,6 +109,9 @@ type UnimplementedNearbyFlightsServer struct {
 func (UnimplementedNearbyFlightsServer) Receive(NearbyFlights_ReceiveServer) error {
 	return status.Errorf(codes.Unimplemented, "method Receive not implemented")
 }
+func (UnimplementedNearbyFlightsServer) ReceiveUpdates(NearbyFlights_ReceiveUpdatesServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReceiveUpdates not implemented")
+}
 func (UnimplementedNearbyFlightsServer) mustEmbedUnimplementedNearbyFlightsServer() {}
 
 // UnsafeNearbyFlightsServer may be embedded to opt out of forward compatibility for this service.
@@ -83,7 +121,7 @@ type UnsafeNearbyFlightsServer interface {
 	mustEmbedUnimplementedNearbyFlightsServer()
 }
 
-func RegisterNearbyFlightsServer(s *grpc.Server, srv NearbyFlightsServer) {
+func RegisterNearbyFlightsServer(s grpc.ServiceRegistrar, srv NearbyFlightsServer) {
 	s.RegisterService(&_NearbyFlights_serviceDesc, srv)
 }
 
@@ -113,6 +151,32 @@ func (x *nearbyFlightsReceiveServer) Recv() (*Options, error) {
 	return m, nil
 }
 
+func _NearbyFlights_ReceiveUpdates_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(NearbyFlightsServer).ReceiveUpdates(&nearbyFlightsReceiveUpdatesServer{stream})
+}
+
+type NearbyFlights_ReceiveUpdatesServer interface {
+	Send(*Update) error
+	Recv() (*Options, error)
+	grpc.ServerStream
+}
+
+type nearbyFlightsReceiveUpdatesServer struct {
+	grpc.ServerStream
+}
+
+func (x *nearbyFlightsReceiveUpdatesServer) Send(m *Update) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *nearbyFlightsReceiveUpdatesServer) Recv() (*Options, error) {
+	m := new(Options)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _NearbyFlights_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "proto.NearbyFlights",
 	HandlerType: (*NearbyFlightsServer)(nil),
@@ -124,6 +188,12 @@ var _NearbyFlights_serviceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "ReceiveUpdates",
+			Handler:       _NearbyFlights_ReceiveUpdates_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "service.proto",
 }
@@ -0,0 +1,44 @@
+package schedule
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ticks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nearbyflights_scheduler_ticks_total",
+		Help: "Searches dispatched by the scheduler.",
+	})
+
+	skipped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nearbyflights_scheduler_skipped_total",
+		Help: "Searches skipped because the previous search of the stream was still running.",
+	})
+
+	merged = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nearbyflights_scheduler_merged_total",
+		Help: "Searches whose flights were merged with the previous ones because the stream hadn't read them yet.",
+	})
+
+	subscriptions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nearbyflights_scheduler_subscriptions",
+		Help: "Subscriptions queued in the scheduler.",
+	})
+
+	searchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "nearbyflights_search_duration_seconds",
+		Help:    "Duration of flight searches in the store, whichever backend serves them.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	searchErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nearbyflights_search_errors_total",
+		Help: "Flight searches that failed.",
+	})
+
+	flightsReturned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nearbyflights_flights_returned_total",
+		Help: "Flights found by searches, before and after filtering the ones already sent to the client.",
+	}, []string{"stage"})
+)
@@ -1,17 +1,30 @@
 package schedule
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
-	"github.com/nearbyflights/nearbyflights/authentication"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nearbyflights/nearbyflights/authentication"
 	"github.com/nearbyflights/nearbyflights/bbox"
 	"github.com/nearbyflights/nearbyflights/db"
 	"github.com/nearbyflights/nearbyflights/dupe"
+	"github.com/nearbyflights/nearbyflights/logging"
+	"github.com/nearbyflights/nearbyflights/tracing"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/nearbyflights/nearbyflights/schedule")
+
+// a flight found again within this long of being sent to a client isn't sent again
+const defaultDedupeWindow = time.Hour
+
 type Options struct {
 	Interval  time.Duration
 	Latitude  float64
@@ -19,37 +32,192 @@ type Options struct {
 	Radius    float64
 }
 
+// Scheduler keeps every subscription in a single queue ordered by the time of its next search,
+// due searches are run by a fixed number of workers.
 type Scheduler struct {
-	Client db.Client
+	store   db.Store
+	workers int
+	mutex   sync.Mutex
+	queue   queue
+	wake    chan struct{}
+	jobs    chan job
+	// dedupeWindow is a time.Duration, read by every worker and changed by SetDedupeWindow
+	dedupeWindow int64
+}
+
+type job struct {
+	subscription *Subscription
+	options      Options
 }
 
-func (s *Scheduler) GetFlights(ctx context.Context, newOptions chan Options) (<-chan []db.Flight, error) {
-	currentOptions := <-newOptions
-	flightsCh := make(chan []db.Flight)
-	ticker := time.NewTicker(currentOptions.Interval)
+func New(store db.Store, workers int) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Scheduler{
+		store:        store,
+		workers:      workers,
+		wake:         make(chan struct{}, 1),
+		jobs:         make(chan job),
+		dedupeWindow: int64(defaultDedupeWindow),
+	}
+}
 
-	go func() {
-		for {
+// SetDedupeWindow changes how long a flight sent to a client isn't sent to it again.
+func (s *Scheduler) SetDedupeWindow(window time.Duration) {
+	atomic.StoreInt64(&s.dedupeWindow, int64(window))
+}
+
+// Run dispatches due searches to the workers until the context is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for i := 0; i < s.workers; i++ {
+		go s.work(ctx)
+	}
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		due, wait := s.due(time.Now())
+
+		for _, j := range due {
 			select {
-			case <-ticker.C:
-				flights, err := s.getFlights(ctx, currentOptions)
-				if err != nil {
-					log.Error(err)
-					continue
-				}
-
-				flightsCh <- flights
-			case receivedOptions := <-newOptions:
-				currentOptions = receivedOptions
-				ticker = time.NewTicker(currentOptions.Interval)
+			case s.jobs <- j:
 			case <-ctx.Done():
-				log.Info("stream closed: finish get flights routine")
 				return
 			}
 		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-ctx.Done():
+			log.Info("server stopped: finish scheduler")
+			return
+		}
+	}
+}
+
+// Subscribe queues the first search of a stream one interval from now, the flights found are
+// delivered on the returned subscription until it is cancelled or the context is done.
+func (s *Scheduler) Subscribe(ctx context.Context, options Options) *Subscription {
+	subscription := &Subscription{
+		ctx:       ctx,
+		scheduler: s,
+		options:   options,
+		next:      time.Now().Add(interval(options)),
+		flights:   make(chan []db.Flight, 1),
+	}
+
+	s.mutex.Lock()
+	heap.Push(&s.queue, subscription)
+	s.mutex.Unlock()
+
+	subscriptions.Inc()
+
+	s.notify()
+
+	return subscription
+}
+
+func (s *Scheduler) due(now time.Time) ([]job, time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var due []job
+	for len(s.queue) > 0 && !s.queue[0].next.After(now) {
+		subscription := s.queue[0]
+
+		if subscription.ctx.Err() != nil {
+			heap.Pop(&s.queue)
+			subscriptions.Dec()
+			continue
+		}
+
+		subscription.next = now.Add(interval(subscription.options))
+		heap.Fix(&s.queue, 0)
+
+		// a search that is still running skips this turn instead of piling up
+		if subscription.running {
+			skipped.Inc()
+			continue
+		}
+
+		ticks.Inc()
+		subscription.running = true
+		due = append(due, job{subscription: subscription, options: subscription.options})
+	}
+
+	if len(s.queue) == 0 {
+		return due, time.Hour
+	}
+
+	return due, s.queue[0].next.Sub(now)
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) work(ctx context.Context) {
+	for {
+		select {
+		case j := <-s.jobs:
+			s.run(j)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Scheduler) run(j job) {
+	subscription := j.subscription
+
+	defer func() {
+		s.mutex.Lock()
+		subscription.running = false
+		s.mutex.Unlock()
 	}()
 
-	return flightsCh, nil
+	ctx, span := tracer.Start(subscription.ctx, "scheduler.tick", trace.WithAttributes(
+		label.Float64("latitude", j.options.Latitude),
+		label.Float64("longitude", j.options.Longitude),
+		label.Float64("radius", j.options.Radius),
+	))
+	defer span.End()
+
+	flights, err := s.getFlights(ctx, j.options)
+	if err != nil {
+		tracing.Fail(span, err)
+		logging.FromContext(ctx).Error(err)
+		return
+	}
+
+	// a stream that hasn't read its last flights gets them along with the new ones, which the dedupe
+	// filter won't find again, and the worker moves on instead of waiting for the stream
+	select {
+	case pending := <-subscription.flights:
+		merged.Inc()
+		flights = append(pending, flights...)
+	default:
+	}
+
+	select {
+	case subscription.flights <- flights:
+	default:
+	}
 }
 
 func (s *Scheduler) getFlights(ctx context.Context, options Options) ([]db.Flight, error) {
@@ -60,24 +228,50 @@ func (s *Scheduler) getFlights(ctx context.Context, options Options) ([]db.Fligh
 
 	boundingBox := bbox.NewBoundingBox(options.Latitude, options.Longitude, options.Radius)
 
-	log.Infof("[%s] search bounds: http://bboxfinder.com/#%v \n", clientId, boundingBox)
-
-	flights, err := s.Client.GetFlights(boundingBox)
+	start := time.Now()
+	flights, err := s.store.GetFlights(ctx, boundingBox)
+	searchDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
+		searchErrors.Inc()
 		return nil, err
 	}
 
-	log.Infof("[%s] returned flights before dupe check: %v", clientId, flights)
+	flightsReturned.WithLabelValues("found").Add(float64(len(flights)))
+
+	newFlights := filter(ctx, clientId, flights, time.Duration(atomic.LoadInt64(&s.dedupeWindow)))
+
+	if logging.Sample(ctx, "search") {
+		logging.FromContext(ctx).Debugf("found %v flight(s), %v new, in http://bboxfinder.com/#%v", len(flights), len(newFlights), boundingBox)
+	}
+
+	flightsReturned.WithLabelValues("new").Add(float64(len(newFlights)))
 
-	newFlights := flights[:0]
+	return newFlights, nil
+}
+
+// filter drops the flights already sent to the client within the window.
+func filter(ctx context.Context, clientId string, flights []db.Flight, window time.Duration) []db.Flight {
+	_, span := tracer.Start(ctx, "dupe.filter", trace.WithAttributes(label.Int("flights", len(flights))))
+	defer span.End()
+
+	newFlights := flights[:0:0]
 
 	for _, f := range flights {
-		if !dupe.Exists(clientId, f.Icao24, time.Hour) {
+		if !dupe.Exists(clientId, f.Icao24, window) {
 			newFlights = append(newFlights, f)
 		}
 	}
 
-	log.Infof("[%s] returned flights after dupe check: %v", clientId, newFlights)
+	span.SetAttributes(label.Int("new_flights", len(newFlights)))
 
-	return newFlights, nil
+	return newFlights
+}
+
+// interval never lets a stream search in a busy loop.
+func interval(options Options) time.Duration {
+	if options.Interval <= 0 {
+		return time.Second
+	}
+
+	return options.Interval
 }
@@ -0,0 +1,129 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/authentication"
+	"github.com/nearbyflights/nearbyflights/bbox"
+	"github.com/nearbyflights/nearbyflights/db"
+)
+
+type store struct{}
+
+func (s store) GetFlights(context.Context, bbox.BoundingBox) ([]db.Flight, error) {
+	return []db.Flight{{Icao24: "AC82EC", Latitude: -23.63, Longitude: -46.66}}, nil
+}
+
+func (s store) GetAllFlights() ([]db.Flight, error) {
+	return nil, nil
+}
+
+func (s store) Close() {}
+
+func newContext(clientId string) (context.Context, context.CancelFunc) {
+	ctx := authentication.NewContext(context.Background(), authentication.Principal{ID: clientId})
+	return context.WithTimeout(ctx, time.Second*5)
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx, cancel := newContext("scheduler-subscribe")
+	defer cancel()
+
+	scheduler := New(store{}, 2)
+	go scheduler.Run(ctx)
+
+	subscription := scheduler.Subscribe(ctx, Options{Interval: time.Millisecond * 10, Latitude: -23.627238, Longitude: -46.655919, Radius: 5000})
+	defer subscription.Cancel()
+
+	select {
+	case flights := <-subscription.Flights():
+		if len(flights) != 1 {
+			t.Errorf("unexpected flights: %v", flights)
+		}
+	case <-ctx.Done():
+		t.Fatal("no flights delivered before the timeout")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx, cancel := newContext("scheduler-update")
+	defer cancel()
+
+	scheduler := New(store{}, 1)
+	go scheduler.Run(ctx)
+
+	subscription := scheduler.Subscribe(ctx, Options{Interval: time.Hour})
+	defer subscription.Cancel()
+
+	subscription.Update(Options{Interval: time.Millisecond * 10, Latitude: -23.627238, Longitude: -46.655919, Radius: 5000})
+
+	select {
+	case <-subscription.Flights():
+	case <-ctx.Done():
+		t.Fatal("updated interval should be used before the timeout")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	scheduler := New(store{}, 1)
+
+	subscriptions := []*Subscription{
+		scheduler.Subscribe(context.Background(), Options{Interval: time.Second}),
+		scheduler.Subscribe(context.Background(), Options{Interval: time.Minute}),
+		scheduler.Subscribe(context.Background(), Options{Interval: time.Hour}),
+	}
+
+	subscriptions[1].Cancel()
+	subscriptions[1].Cancel()
+
+	if len(scheduler.queue) != 2 {
+		t.Errorf("queue should have 2 subscriptions, got %v", len(scheduler.queue))
+	}
+
+	for i, s := range scheduler.queue {
+		if s.index != i {
+			t.Errorf("subscription at %v has index %v", i, s.index)
+		}
+	}
+}
+
+func TestRun_SlowSubscriber(t *testing.T) {
+	ctx, cancel := newContext("scheduler-slow")
+	defer cancel()
+
+	// a single worker, which a blocked delivery would take away from every other subscription
+	scheduler := New(store{}, 1)
+	go scheduler.Run(ctx)
+
+	options := Options{Interval: time.Millisecond * 10, Latitude: -23.627238, Longitude: -46.655919, Radius: 5000}
+
+	slow := scheduler.Subscribe(ctx, options)
+	defer slow.Cancel()
+
+	fastCtx, fastCancel := newContext("scheduler-fast")
+	defer fastCancel()
+
+	fast := scheduler.Subscribe(fastCtx, options)
+	defer fast.Cancel()
+
+	// the slow subscription never reads while the fast one gets several searches
+	for i := 0; i < 5; i++ {
+		select {
+		case <-fast.Flights():
+		case <-ctx.Done():
+			t.Fatal("a subscription that never reads stalled the others")
+		}
+	}
+
+	// its flights wait for it, merged with the later searches
+	select {
+	case flights := <-slow.Flights():
+		if len(flights) != 1 || flights[0].Icao24 != "AC82EC" {
+			t.Errorf("expected the pending flight, got %v", flights)
+		}
+	case <-ctx.Done():
+		t.Fatal("the slow subscription lost its flights")
+	}
+}
@@ -0,0 +1,77 @@
+package schedule
+
+import (
+	"container/heap"
+	"context"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/db"
+)
+
+type Subscription struct {
+	ctx       context.Context
+	scheduler *Scheduler
+	options   Options
+	next      time.Time
+	index     int
+	running   bool
+	flights   chan []db.Flight
+}
+
+func (s *Subscription) Flights() <-chan []db.Flight {
+	return s.flights
+}
+
+// Update replaces the search options, the next search runs one new interval from now.
+func (s *Subscription) Update(options Options) {
+	s.scheduler.mutex.Lock()
+	s.options = options
+	s.next = time.Now().Add(interval(options))
+	if s.index >= 0 {
+		heap.Fix(&s.scheduler.queue, s.index)
+	}
+	s.scheduler.mutex.Unlock()
+
+	s.scheduler.notify()
+}
+
+func (s *Subscription) Cancel() {
+	s.scheduler.mutex.Lock()
+	if s.index >= 0 {
+		heap.Remove(&s.scheduler.queue, s.index)
+		subscriptions.Dec()
+	}
+	s.scheduler.mutex.Unlock()
+}
+
+// queue is a min-heap of subscriptions ordered by their next search.
+type queue []*Subscription
+
+func (q queue) Len() int {
+	return len(q)
+}
+
+func (q queue) Less(i, j int) bool {
+	return q[i].next.Before(q[j].next)
+}
+
+func (q queue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *queue) Push(x interface{}) {
+	s := x.(*Subscription)
+	s.index = len(*q)
+	*q = append(*q, s)
+}
+
+func (q *queue) Pop() interface{} {
+	old := *q
+	s := old[len(old)-1]
+	old[len(old)-1] = nil
+	s.index = -1
+	*q = old[:len(old)-1]
+	return s
+}
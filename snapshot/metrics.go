@@ -0,0 +1,23 @@
+package snapshot
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	refreshedAt = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nearbyflights_snapshot_loaded_timestamp_seconds",
+		Help: "Unix time of the last successful snapshot refresh, the data is as fresh as this.",
+	})
+
+	refreshErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nearbyflights_snapshot_refresh_errors_total",
+		Help: "Snapshot refreshes that failed.",
+	})
+
+	flightsLoaded = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nearbyflights_snapshot_flights",
+		Help: "Flights in the current snapshot.",
+	})
+)
@@ -0,0 +1,124 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/bbox"
+	"github.com/nearbyflights/nearbyflights/db"
+	"github.com/nearbyflights/nearbyflights/grid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshot serves flight searches from an in-memory grid of every current flight, reloaded
+// from the underlying store on each refresh, so the store sees one query per refresh
+// regardless of how many streams are searching.
+type Snapshot struct {
+	source   db.Store
+	cellSize float64
+	index    atomic.Value
+	loadedAt atomic.Value
+}
+
+func New(source db.Store, cellSize float64) *Snapshot {
+	return &Snapshot{source: source, cellSize: cellSize}
+}
+
+func (s *Snapshot) Refresh() error {
+	start := time.Now()
+
+	flights, err := s.source.GetAllFlights()
+	if err != nil {
+		refreshErrors.Inc()
+		return err
+	}
+
+	index := grid.New(s.cellSize)
+	for _, f := range flights {
+		index.Insert(f)
+	}
+
+	s.index.Store(index)
+	s.loadedAt.Store(time.Now())
+
+	refreshedAt.SetToCurrentTime()
+	flightsLoaded.Set(float64(index.Len()))
+
+	log.Infof("snapshot refreshed with %v flight(s) in %v", index.Len(), time.Since(start))
+
+	return nil
+}
+
+// Run refreshes the snapshot every period until the context is done.
+func (s *Snapshot) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Refresh()
+			if err != nil {
+				log.Errorf("error refreshing snapshot, serving the one loaded at %v: %v", s.LoadedAt(), err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// LoadedAt returns when the snapshot was last refreshed, or the zero time if it never was.
+func (s *Snapshot) LoadedAt() time.Time {
+	loadedAt, _ := s.loadedAt.Load().(time.Time)
+	return loadedAt
+}
+
+// GetFlights searches the snapshot, or the underlying store while no snapshot has been loaded yet.
+func (s *Snapshot) GetFlights(ctx context.Context, box bbox.BoundingBox) ([]db.Flight, error) {
+	index, ok := s.index.Load().(*grid.Index)
+	if !ok {
+		return s.source.GetFlights(ctx, box)
+	}
+
+	return index.Search(box), nil
+}
+
+func (s *Snapshot) GetAllFlights() ([]db.Flight, error) {
+	index, ok := s.index.Load().(*grid.Index)
+	if !ok {
+		return s.source.GetAllFlights()
+	}
+
+	return index.All(), nil
+}
+
+// LastUpdate returns the older of the last refresh and the last update of the underlying store,
+// the snapshot serves neither updates made after it was loaded nor anything before its first load.
+func (s *Snapshot) LastUpdate(ctx context.Context) (time.Time, error) {
+	loadedAt := s.LoadedAt()
+	if loadedAt.IsZero() {
+		return time.Time{}, errors.New("the snapshot hasn't been loaded yet")
+	}
+
+	freshness, ok := s.source.(db.Freshness)
+	if !ok {
+		return loadedAt, nil
+	}
+
+	updated, err := freshness.LastUpdate(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if updated.Before(loadedAt) {
+		return updated, nil
+	}
+
+	return loadedAt, nil
+}
+
+func (s *Snapshot) Close() {
+	s.source.Close()
+}
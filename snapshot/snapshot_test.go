@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/bbox"
+	"github.com/nearbyflights/nearbyflights/db"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// 5km radius in CGH airport
+var box = bbox.NewBoundingBox(-23.627238, -46.655919, 5000)
+
+type store struct {
+	flights  []db.Flight
+	searches int
+}
+
+func (s *store) GetFlights(context.Context, bbox.BoundingBox) ([]db.Flight, error) {
+	s.searches++
+	return s.flights, nil
+}
+
+func (s *store) GetAllFlights() ([]db.Flight, error) {
+	return s.flights, nil
+}
+
+func (s *store) Close() {}
+
+func TestGetFlights(t *testing.T) {
+	source := &store{flights: []db.Flight{{Icao24: "inside", Latitude: -23.63, Longitude: -46.66}}}
+	snapshot := New(source, 1)
+
+	err := snapshot.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded := testutil.ToFloat64(flightsLoaded); loaded != 1 {
+		t.Errorf("snapshot_flights metric should be 1, got %v", loaded)
+	}
+
+	source.flights = nil
+
+	for i := 0; i < 3; i++ {
+		flights, _ := snapshot.GetFlights(context.Background(), box)
+		if len(flights) != 1 {
+			t.Errorf("flights should be served from the snapshot: %v", flights)
+		}
+	}
+
+	if source.searches != 0 {
+		t.Errorf("source should not be searched once the snapshot is loaded, got %v searches", source.searches)
+	}
+}
+
+func TestGetFlights_NotLoaded(t *testing.T) {
+	source := &store{flights: []db.Flight{{Icao24: "inside", Latitude: -23.63, Longitude: -46.66}}}
+	snapshot := New(source, 1)
+
+	flights, _ := snapshot.GetFlights(context.Background(), box)
+
+	if len(flights) != 1 || source.searches != 1 {
+		t.Errorf("source should be searched until the snapshot is loaded")
+	}
+}
+
+type freshStore struct {
+	store
+	lastUpdate time.Time
+}
+
+func (s *freshStore) LastUpdate(context.Context) (time.Time, error) {
+	return s.lastUpdate, nil
+}
+
+func TestLastUpdate(t *testing.T) {
+	source := &freshStore{}
+	snapshot := New(source, 1)
+
+	if _, err := snapshot.LastUpdate(context.Background()); err == nil {
+		t.Error("expected an error before the snapshot is loaded")
+	}
+
+	err := snapshot.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the store was updated after the refresh, the snapshot is as old as the refresh
+	source.lastUpdate = time.Now().Add(time.Minute)
+	updated, _ := snapshot.LastUpdate(context.Background())
+	if !updated.Equal(snapshot.LoadedAt()) {
+		t.Errorf("expected the refresh time %v, got %v", snapshot.LoadedAt(), updated)
+	}
+
+	// the store wasn't updated for a while, the snapshot is as old as its data
+	source.lastUpdate = time.Now().Add(-time.Hour)
+	updated, _ = snapshot.LastUpdate(context.Background())
+	if !updated.Equal(source.lastUpdate) {
+		t.Errorf("expected the store update time %v, got %v", source.lastUpdate, updated)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/db"
+)
+
+func TestNewStore_EmbeddedImport(t *testing.T) {
+	dir := t.TempDir()
+	importPath := filepath.Join(dir, "flights.import")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	store, err := newStore(ctx, Configuration{
+		StorageBackend:         "embedded",
+		EmbeddedPath:           filepath.Join(dir, "flights.db"),
+		EmbeddedCellSize:       1,
+		EmbeddedImportPath:     importPath,
+		EmbeddedImportInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		store.Close()
+	}()
+
+	// feeders write the records elsewhere and rename the file into place
+	feed := func(records string) {
+		err := ioutil.WriteFile(importPath+".tmp", []byte(records), 0600)
+		if err == nil {
+			err = os.Rename(importPath+".tmp", importPath)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	served := func(count int) []db.Flight {
+		for i := 0; i < 100; i++ {
+			flights, err := store.GetAllFlights()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(importPath); os.IsNotExist(err) && len(flights) == count {
+				return flights
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		t.Fatalf("expected %v flight(s) to be served after the import", count)
+		return nil
+	}
+
+	feed(`{"op": "put", "icao": "e48df6", "latitude": -23.63, "longitude": -46.66, "call_sign": "GLO1234"}
+{"op": "put", "icao": "e48df7", "latitude": -23.62, "longitude": -46.65}
+`)
+
+	flights := served(2)
+	for _, f := range flights {
+		if f.Icao24 == "e48df6" && f.CallSign != "GLO1234" {
+			t.Errorf("expected the imported call sign, got %+v", f)
+		}
+	}
+
+	updated, err := store.(db.Freshness).LastUpdate(ctx)
+	if err != nil || time.Since(updated) > time.Minute {
+		t.Errorf("expected the import to refresh the last update, got %v %v", updated, err)
+	}
+
+	feed(`{"op": "delete", "icao": "e48df7"}
+`)
+
+	flights = served(1)
+	if flights[0].Icao24 != "e48df6" {
+		t.Errorf("expected the flight that wasn't deleted, got %+v", flights[0])
+	}
+}
@@ -0,0 +1,195 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	reloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nearbyflights_tls_reloads_total",
+		Help: "Reloads of the TLS certificate and client CA bundle by result.",
+	}, []string{"result"})
+
+	expiry = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nearbyflights_tls_certificate_expiry_timestamp_seconds",
+		Help: "Unix time when the served TLS certificate expires.",
+	})
+)
+
+type Options struct {
+	CertificatePath string
+	KeyPath         string
+	// ClientCAPath is the bundle client certificates are verified against, empty doesn't ask for them.
+	ClientCAPath string
+	// ClientAuth is the client certificate policy used with ClientCAPath.
+	ClientAuth tls.ClientAuthType
+}
+
+// Reloader serves the TLS certificate and client CA bundle found on disk, so rotated files are picked
+// up by new connections without restarting the server. Open connections keep their handshake.
+type Reloader struct {
+	options Options
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modified    map[string]time.Time
+}
+
+func New(options Options) (*Reloader, error) {
+	r := &Reloader{options: options}
+
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the files again, if any of them changed since the last load. The previous
+// certificate and bundle are kept when the new ones are invalid, such as a key that doesn't
+// match the certificate because only one of them was written so far.
+func (r *Reloader) Reload() error {
+	modified, changed, err := r.changed()
+	if err != nil || !changed {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.options.CertificatePath, r.options.KeyPath)
+	if err != nil {
+		reloads.WithLabelValues("error").Inc()
+		return fmt.Errorf("error loading TLS certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		reloads.WithLabelValues("error").Inc()
+		return fmt.Errorf("error parsing TLS certificate: %v", err)
+	}
+	certificate.Leaf = leaf
+
+	var pool *x509.CertPool
+	if r.options.ClientCAPath != "" {
+		ca, err := ioutil.ReadFile(r.options.ClientCAPath)
+		if err != nil {
+			reloads.WithLabelValues("error").Inc()
+			return fmt.Errorf("error reading client CA bundle: %v", err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			reloads.WithLabelValues("error").Inc()
+			return fmt.Errorf("no certificates found in %s", r.options.ClientCAPath)
+		}
+	}
+
+	r.mutex.Lock()
+	r.certificate = &certificate
+	r.clientCAs = pool
+	r.modified = modified
+	r.mutex.Unlock()
+
+	reloads.WithLabelValues("success").Inc()
+	expiry.Set(float64(leaf.NotAfter.Unix()))
+
+	log.Infof("loaded TLS certificate for %v expiring at %v", leaf.Subject.CommonName, leaf.NotAfter.UTC().Format(time.RFC3339))
+
+	return nil
+}
+
+// Run reloads the files every interval until the context is done.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := r.Reload()
+			if err != nil {
+				log.Errorf("error reloading TLS files, keeping the previous ones: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Config returns a TLS configuration whose handshakes always use the latest certificate and client CA bundle.
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		GetCertificate:     r.getCertificate,
+		GetConfigForClient: r.getConfigForClient,
+	}
+}
+
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.certificate, nil
+}
+
+// getConfigForClient verifies client certificates with the current bundle, the ClientCAs of a
+// tls.Config can't change once the server uses it.
+func (r *Reloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.clientCAs == nil {
+		return nil, nil
+	}
+
+	return &tls.Config{
+		GetCertificate: r.getCertificate,
+		ClientCAs:      r.clientCAs,
+		ClientAuth:     r.options.ClientAuth,
+		// the config replaces the one set up by gRPC, which negotiates HTTP/2
+		NextProtos: []string{"h2"},
+	}, nil
+}
+
+// changed returns the modification times of the files and whether they differ from the last load.
+func (r *Reloader) changed() (map[string]time.Time, bool, error) {
+	modified := make(map[string]time.Time)
+
+	for _, path := range []string{r.options.CertificatePath, r.options.KeyPath, r.options.ClientCAPath} {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, false, err
+		}
+
+		modified[path] = info.ModTime()
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if len(modified) != len(r.modified) {
+		return modified, true, nil
+	}
+
+	for path, t := range modified {
+		if !r.modified[path].Equal(t) {
+			return modified, true, nil
+		}
+	}
+
+	return modified, false, nil
+}
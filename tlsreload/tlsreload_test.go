@@ -0,0 +1,193 @@
+package tlsreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for name and its key, and moves their
+// modification time forward so the reloader notices them even within the same second.
+func writeCertificate(t *testing.T, certificatePath string, keyPath string, name string, modified time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		certificatePath: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPath:         pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}),
+	}
+
+	for path, data := range files {
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func served(t *testing.T, r *Reloader) string {
+	certificate, err := r.Config().GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate.Leaf.Subject.CommonName
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsreload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certificatePath, keyPath := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	now := time.Now()
+
+	writeCertificate(t, certificatePath, keyPath, "first", now)
+
+	r, err := New(Options{CertificatePath: certificatePath, KeyPath: keyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := served(t, r); name != "first" {
+		t.Fatalf("expected the first certificate, got %v", name)
+	}
+
+	writeCertificate(t, certificatePath, keyPath, "second", now.Add(time.Minute))
+
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if name := served(t, r); name != "second" {
+		t.Errorf("expected the rotated certificate, got %v", name)
+	}
+
+	// a certificate written without its key yet must not replace the working one
+	otherKey := filepath.Join(dir, "other.key")
+	writeCertificate(t, certificatePath, otherKey, "third", now.Add(2*time.Minute))
+
+	if err := r.Reload(); err == nil {
+		t.Error("expected an error for a certificate not matching its key")
+	}
+
+	if name := served(t, r); name != "second" {
+		t.Errorf("expected the previous certificate to be kept, got %v", name)
+	}
+}
+
+func TestClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsreload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certificatePath, keyPath := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	caPath, caKeyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	now := time.Now()
+
+	writeCertificate(t, certificatePath, keyPath, "localhost", now)
+	writeCertificate(t, caPath, caKeyPath, "old-client", now)
+
+	r, err := New(Options{CertificatePath: certificatePath, KeyPath: keyPath, ClientCAPath: caPath, ClientAuth: tls.RequireAndVerifyClientCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client certificate is self-signed, so the bundle trusts exactly one client
+	writeCertificate(t, caPath, caKeyPath, "new-client", now.Add(time.Minute))
+
+	if err := handshake(r, certificatePath, caPath, caKeyPath); err == nil {
+		t.Error("expected the new client to be rejected before the bundle is reloaded")
+	}
+
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handshake(r, certificatePath, caPath, caKeyPath); err != nil {
+		t.Errorf("expected the new client to be accepted after the bundle is reloaded: %v", err)
+	}
+}
+
+func handshake(r *Reloader, serverCertificatePath string, clientCertificatePath string, clientKeyPath string) error {
+	client, err := tls.LoadX509KeyPair(clientCertificatePath, clientKeyPath)
+	if err != nil {
+		return err
+	}
+
+	serverCA, err := ioutil.ReadFile(serverCertificatePath)
+	if err != nil {
+		return err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCA)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", r.Config())
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+
+		errs <- conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "localhost", RootCAs: roots, Certificates: []tls.Certificate{client}})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// with TLS 1.3 the client finishes its handshake before the server verifies its certificate
+	return <-errs
+}
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagation"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Options struct {
+	// Exporter is where spans are sent: none, stdout or otlp.
+	Exporter string
+	// Endpoint is the address of the OTLP collector.
+	Endpoint string
+	// Insecure connects to the collector without TLS.
+	Insecure bool
+	// SampleRatio is the fraction of new traces recorded, traces started by the client follow its decision.
+	SampleRatio float64
+	ServiceName string
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator, the returned
+// function flushes the pending spans. With the none exporter nothing is recorded.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter exporttrace.SpanExporter
+
+	switch options.Exporter {
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		e, err := stdout.NewExporter(stdout.WithWriter(os.Stdout), stdout.WithoutMetricExport())
+		if err != nil {
+			return nil, fmt.Errorf("error creating stdout exporter: %v", err)
+		}
+
+		exporter = e
+	case "otlp":
+		driverOptions := []otlpgrpc.Option{otlpgrpc.WithEndpoint(options.Endpoint)}
+		if options.Insecure {
+			driverOptions = append(driverOptions, otlpgrpc.WithInsecure())
+		}
+
+		e, err := otlp.NewExporter(ctx, otlpgrpc.NewDriver(driverOptions...))
+		if err != nil {
+			return nil, fmt.Errorf("error creating OTLP exporter: %v", err)
+		}
+
+		exporter = e
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", options.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))}),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(options.ServiceName))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Fail marks the span as failed with the error.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,22 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Options{Exporter: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown without an exporter should do nothing, got %v", err)
+	}
+
+	_, err = Setup(context.Background(), Options{Exporter: "zipkin"})
+	if err == nil {
+		t.Error("unknown exporters should be refused")
+	}
+}
@@ -0,0 +1,154 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nearbyflights/nearbyflights/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	lastUpdate = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nearbyflights_flights_last_update_timestamp_seconds",
+		Help: "Unix time of the newest flight update in the store.",
+	})
+
+	stale = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nearbyflights_flights_stale",
+		Help: "1 while the newest flight update is older than the freshness threshold.",
+	})
+)
+
+// State is the freshness of the flights in the store.
+type State struct {
+	Stale      bool
+	LastUpdate time.Time
+}
+
+// Watchdog polls the newest flight update in the store and tells subscribers when the flights
+// turn stale or fresh again.
+type Watchdog struct {
+	store     db.Freshness
+	threshold time.Duration
+
+	mutex       sync.Mutex
+	state       State
+	checked     bool
+	err         error
+	subscribers map[chan State]struct{}
+}
+
+func New(store db.Freshness, threshold time.Duration) *Watchdog {
+	return &Watchdog{store: store, threshold: threshold, subscribers: make(map[chan State]struct{})}
+}
+
+// Run checks the store every interval until the context is done.
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		w.Check(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check reads the newest update from the store. Flights are stale when it's older than the
+// threshold; when the store can't be read they are stale once the last known update is.
+func (w *Watchdog) Check(ctx context.Context) {
+	updated, err := w.store.LastUpdate(ctx)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.err = err
+	if err == nil {
+		w.state.LastUpdate = updated
+		lastUpdate.Set(float64(updated.Unix()))
+	} else {
+		log.Errorf("error reading the last flight update: %v", err)
+	}
+
+	isStale := w.state.LastUpdate.IsZero() || time.Since(w.state.LastUpdate) > w.threshold
+	if w.checked && isStale == w.state.Stale {
+		return
+	}
+
+	w.checked = true
+	w.state.Stale = isStale
+
+	if isStale {
+		log.Warnf("flights are stale, last updated at %v", w.state.LastUpdate)
+		stale.Set(1)
+	} else {
+		log.Infof("flights are fresh, last updated at %v", w.state.LastUpdate)
+		stale.Set(0)
+	}
+
+	for subscriber := range w.subscribers {
+		// subscribers only care about the latest state
+		select {
+		case <-subscriber:
+		default:
+		}
+
+		subscriber <- w.state
+	}
+}
+
+func (w *Watchdog) State() State {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.state
+}
+
+// Subscribe returns a channel receiving the state whenever it changes, and the function to stop it.
+func (w *Watchdog) Subscribe() (<-chan State, func()) {
+	subscriber := make(chan State, 1)
+
+	w.mutex.Lock()
+	w.subscribers[subscriber] = struct{}{}
+	w.mutex.Unlock()
+
+	return subscriber, func() {
+		w.mutex.Lock()
+		delete(w.subscribers, subscriber)
+		w.mutex.Unlock()
+	}
+}
+
+// Probe is a health check failing while the flights are stale.
+func (w *Watchdog) Probe(context.Context) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.checked {
+		return errors.New("freshness not checked yet")
+	}
+
+	if w.state.Stale {
+		if w.err != nil {
+			return fmt.Errorf("flights last updated at %v, error reading updates: %v", w.state.LastUpdate, w.err)
+		}
+
+		if w.state.LastUpdate.IsZero() {
+			return errors.New("flights were never updated")
+		}
+
+		return fmt.Errorf("flights were last updated %v ago", time.Since(w.state.LastUpdate).Round(time.Second))
+	}
+
+	return nil
+}
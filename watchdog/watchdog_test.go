@@ -0,0 +1,68 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type store struct {
+	updated time.Time
+	err     error
+}
+
+func (s *store) LastUpdate(context.Context) (time.Time, error) {
+	return s.updated, s.err
+}
+
+func TestCheck(t *testing.T) {
+	source := &store{updated: time.Now()}
+	watchdog := New(source, time.Minute)
+
+	states, unsubscribe := watchdog.Subscribe()
+	defer unsubscribe()
+
+	watchdog.Check(context.Background())
+
+	if state := <-states; state.Stale {
+		t.Error("recent updates should be fresh")
+	}
+
+	if err := watchdog.Probe(context.Background()); err != nil {
+		t.Errorf("fresh flights should pass the health check: %v", err)
+	}
+
+	source.updated = time.Now().Add(-time.Hour)
+	watchdog.Check(context.Background())
+
+	if state := <-states; !state.Stale {
+		t.Error("old updates should be stale")
+	}
+
+	if err := watchdog.Probe(context.Background()); err == nil {
+		t.Error("stale flights should fail the health check")
+	}
+
+	watchdog.Check(context.Background())
+
+	select {
+	case state := <-states:
+		t.Errorf("subscribers should only be told about changes, got %v", state)
+	default:
+	}
+}
+
+func TestCheck_Error(t *testing.T) {
+	source := &store{updated: time.Now()}
+	watchdog := New(source, time.Minute)
+
+	watchdog.Check(context.Background())
+
+	source.err = errors.New("connection refused")
+	watchdog.Check(context.Background())
+
+	if watchdog.State().Stale {
+		t.Error("flights should stay fresh until the last known update is too old")
+	}
+}